@@ -2,21 +2,93 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"strconv"
+	"time"
 )
 
+// ThumbnailSize is a named thumbnail width (e.g. small:320)
+type ThumbnailSize struct {
+	Name  string
+	Width int
+}
+
+// HotnessConfig holds the weights of the hotness formula
+// hotness = views*ViewWeight + likes*LikeWeight + RecencyBonus*0.5^(age/HalfLife)
+type HotnessConfig struct {
+	ViewWeight   float64       // Points per view
+	LikeWeight   float64       // Points per like
+	RecencyBonus float64       // Bonus for a video viewed just now, halved every HalfLife
+	HalfLife     time.Duration // Time for the recency bonus to halve, 0 disables the bonus
+	ByWatchTime  bool          // Count accumulated watch time (5 minutes per view) instead of views
+}
+
 type Config struct {
 	VideoDirs     []string // Multiple video directories
 	VideoDir      string   // First video directory (for backward compatibility)
 	ThumbnailDir  string
 	DataDir       string
+	DBMaxOpenConns int // Open SQLite connections; WAL lets several readers run alongside a writer (default: 4)
 	JWTSecret     string
 	Username      string
 	Password      string
+	PasswordHash  string // bcrypt hash of the password, takes precedence over Password when set
+	TokenTTL        time.Duration // Lifetime of access tokens (default: 24h)
+	RefreshTokenTTL time.Duration // Lifetime of refresh tokens, renewed on every refresh (default: 720h)
 	Env           string
+	LogFormat     string // Log output: "text" or "json" with levels and per-request IDs (default: "text")
+	PreviewsEnabled  bool     // Generate and serve hover previews (default: true)
 	PreviewSegments  int      // Number of preview segments (default: 60)
+	PreviewSegmentDuration float64 // Length of each preview segment in seconds (default: 0.5)
+	PreviewCRF       int      // x264 CRF for previews, higher is smaller/worse (default: 28)
+	PreviewPreset    string   // x264 preset for previews (default: fast)
+	HashMode         string   // Content hash used to name thumbnails/previews: "fast" (first 1MB), "full" or "sampled" (default: "fast")
+	SortTieBreak     string   // Secondary sort key for ties in the video list: "name", "modified" or "size" (default: "name")
+	MinFreeMemMB     int64    // Pause generation workers while available memory is below this, 0 disables (default: 0)
+	GenerationMode   string   // Startup generation: "parallel" or "sequential" (thumbnails, then previews) (default: "parallel")
+	GenerationWorkers int     // Concurrent videos per generation job, overridable per request (default: 4)
+	CleanupOnStart   bool     // Delete orphaned cache files and leftover temp dirs on startup (default: false)
+	FFmpegThreads    int      // Threads per preview encode; 0 splits the CPUs across workers (default: 0)
+	FFmpegPath       string   // ffmpeg binary, a name looked up in PATH or a full path (default: "ffmpeg")
+	FFprobePath      string   // ffprobe binary, a name looked up in PATH or a full path (default: "ffprobe")
+	FFmpegArgs       []string // Extra arguments placed first on every ffmpeg call, e.g. "-hwaccel cuda" (default: none)
+	HWAccel          string   // Preview encoder: "none" (libx264), "nvenc", "qsv" or "vaapi" (default: "none")
+	HWAccelDevice    string   // VA-API render node (default: /dev/dri/renderD128)
+	FFmpegTimeout    time.Duration // Limit for generating one video's thumbnail or preview, 0 disables (default: 10m)
+	FFmpegRetry      bool     // Retry a failed or timed out thumbnail/preview once (default: false)
+	MaxConcurrentFFmpeg int   // Thumbnail/preview ffmpeg runs at once, batch and on-demand together, 0 is unlimited (default: 4)
+	FFmpegQueueTimeout time.Duration // How long an on-demand request waits for a free run before a 503, 0 waits forever (default: 30s)
+	ValidateVideos   bool     // Check new files with ffprobe while indexing and quarantine corrupt ones (default: false)
+	PreviewFormat    string   // Hover preview format: "mp4", "webp" or "gif" (default: "mp4")
+	VideoExtensions  []string // Recognized video file extensions (default: .mp4)
+	MinFileSize      int64    // Skip video files smaller than this many bytes, 0 disables (default: 10MB)
+	MaxPathLength    int      // Maximum length in bytes of a prefixed video path (default: 1024)
+	StreamCacheMaxAge time.Duration // How long clients may reuse a streamed video before revalidating (default: 1h)
+	IndexRefreshInterval time.Duration // How often the video index is rescanned, 0 disables (default: 5m)
+	WatchDirs            bool          // Watch video directories for changes with inotify (default: false)
+	WatchStableDuration  time.Duration // How long a new file's size must be unchanged before indexing (default: 5s)
+	WatchPruneStats      bool          // Delete stats of videos removed from disk (default: false, stats are kept)
+	ThumbnailMaxAge      time.Duration // Regenerate thumbnails older than this during batch runs, 0 disables (default: 0)
+	ThumbnailPosition    string        // Thumbnail frame position: seconds ("30"), percentage ("10%") or "smart" (default: "50%")
+	ThumbnailDirPositions []string     // Per-directory overrides of ThumbnailPosition, parallel to VideoDirs ("" uses the default)
+	ThumbnailSizes       []ThumbnailSize // Resized thumbnail variants, the full frame is always kept as "large" (default: small:320,medium:640)
+	ThumbnailFormat      string        // Thumbnail format served to supporting clients: "jpeg" or "webp" (default: "jpeg")
+	ThumbnailLayout      string        // Cache file layout in ThumbnailDir: "flat" or "sharded" by the first two hash characters (default: "flat")
+	PlaceholderThumbnail string        // JPEG served when a thumbnail can't be generated (default: a built-in grey frame)
+	WatchThreshold       time.Duration // Watch time before a watch session counts as a view (default: 30s)
+	WatchSessionTTL      time.Duration // Idle time after which a watch session expires (default: 30m)
+	ContinueMinPosition  time.Duration // Playback position a video must pass to show up in continue watching (default: 10s)
+	ContinueLimit        int           // Most videos returned by continue watching (default: 20)
+	Hotness              HotnessConfig // Hotness formula weights
+	ShutdownTimeout      time.Duration // How long shutdown waits for requests and running generation jobs (default: 30s)
+	BindAddr             string        // Address to listen on, "host:port" or a host using PORT (default: ":8080")
+	TLSCert              string        // TLS certificate file, serves HTTPS together with TLSKey (default: none)
+	TLSKey               string        // TLS private key file (default: none)
+	UnixSocket           string        // Listen on this Unix socket instead of BindAddr, e.g. behind nginx (default: none)
+	AllowedOrigins       []string      // Origins allowed to call the API from a browser, "*" for any (default: same-origin only)
+	CookieSecure         bool          // Mark auth cookies Secure, e.g. behind an HTTPS proxy; always set when serving TLS (default: false)
 }
 
 func Load() *Config {
@@ -28,15 +100,146 @@ func Load() *Config {
 
 	return &Config{
 		VideoDirs:    videoDirs,
+		ThumbnailDirPositions: parseDirOverrides("THUMBNAIL_POSITION", len(videoDirs)),
 		VideoDir:     videoDir,
 		ThumbnailDir: getEnv("THUMBNAIL_DIR", "./thumbnails"),
 		DataDir:      getEnv("DATA_DIR", "./data"),
+		DBMaxOpenConns: getEnvInt("DB_MAX_OPEN_CONNS", 4),
 		JWTSecret:    getEnv("JWT_SECRET", "streamlet-secret-change-me"),
 		Username:     getEnv("AUTH_USER", "admin"),
 		Password:     getEnv("AUTH_PASS", "admin123"),
+		PasswordHash: getEnv("AUTH_PASS_HASH", ""),
+		TokenTTL:        getEnvDuration("TOKEN_TTL", 24*time.Hour),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
 		Env:             getEnv("ENV", "development"),
+		LogFormat:       strings.ToLower(getEnv("LOG_FORMAT", "text")),
+		PreviewsEnabled: getEnvBool("PREVIEWS_ENABLED", true),
 		PreviewSegments: getEnvInt("PREVIEW_SEGMENTS", 60),
+		PreviewSegmentDuration: getEnvFloat("PREVIEW_SEGMENT_DURATION", 0.5),
+		PreviewCRF:      getEnvInt("PREVIEW_CRF", 28),
+		PreviewPreset:   getEnv("PREVIEW_PRESET", "fast"),
+		HashMode:        strings.ToLower(getEnv("HASH_MODE", "fast")),
+		SortTieBreak:    strings.ToLower(getEnv("SORT_TIE_BREAK", "name")),
+		MinFreeMemMB:    getEnvInt64("MIN_FREE_MEM_MB", 0),
+		GenerationMode:  strings.ToLower(getEnv("GENERATION_MODE", "parallel")),
+		GenerationWorkers: getEnvInt("GENERATION_WORKERS", 4),
+		CleanupOnStart:  getEnvBool("CLEANUP_ON_START", false),
+		FFmpegThreads:   getEnvInt("FFMPEG_THREADS", 0),
+		FFmpegPath:      getEnv("FFMPEG_PATH", "ffmpeg"),
+		FFprobePath:     getEnv("FFPROBE_PATH", "ffprobe"),
+		FFmpegArgs:      strings.Fields(getEnv("FFMPEG_ARGS", "")),
+		HWAccel:         strings.ToLower(getEnv("HWACCEL", "none")),
+		HWAccelDevice:   getEnv("HWACCEL_DEVICE", ""),
+		FFmpegTimeout:   getEnvDuration("FFMPEG_TIMEOUT", 10*time.Minute),
+		FFmpegRetry:     getEnvBool("FFMPEG_RETRY", false),
+		MaxConcurrentFFmpeg: getEnvInt("MAX_CONCURRENT_FFMPEG", 4),
+		FFmpegQueueTimeout: getEnvDuration("FFMPEG_QUEUE_TIMEOUT", 30*time.Second),
+		ValidateVideos:  getEnvBool("VALIDATE_VIDEOS", false),
+		PreviewFormat:   strings.ToLower(getEnv("PREVIEW_FORMAT", "mp4")),
+		VideoExtensions: parseExtensions(getEnv("VIDEO_EXTENSIONS", ".mp4")),
+		MinFileSize:     getEnvInt64("MIN_FILE_SIZE", 10*1024*1024),
+		MaxPathLength:   getEnvInt("MAX_PATH_LENGTH", 1024),
+		StreamCacheMaxAge: getEnvDuration("STREAM_CACHE_MAX_AGE", time.Hour),
+		IndexRefreshInterval: getEnvDuration("INDEX_REFRESH_INTERVAL", 5*time.Minute),
+		WatchDirs:            getEnvBool("WATCH_DIRS", false),
+		WatchStableDuration:  getEnvDuration("WATCH_STABLE_DURATION", 5*time.Second),
+		WatchPruneStats:      getEnvBool("WATCH_PRUNE_STATS", false),
+		ThumbnailMaxAge:      getEnvDuration("THUMBNAIL_MAX_AGE", 0),
+		ThumbnailPosition:    getEnv("THUMBNAIL_POSITION", "50%"),
+		ThumbnailSizes:       parseThumbnailSizes(getEnv("THUMBNAIL_SIZES", "small:320,medium:640")),
+		ThumbnailFormat:      strings.ToLower(getEnv("THUMBNAIL_FORMAT", "jpeg")),
+		ThumbnailLayout:      strings.ToLower(getEnv("THUMBNAIL_LAYOUT", "flat")),
+		PlaceholderThumbnail: getEnv("PLACEHOLDER_THUMBNAIL", ""),
+		WatchThreshold:       getEnvDuration("WATCH_THRESHOLD", 30*time.Second),
+		WatchSessionTTL:      getEnvDuration("WATCH_SESSION_TTL", 30*time.Minute),
+		ContinueMinPosition:  getEnvDuration("CONTINUE_MIN_POSITION", 10*time.Second),
+		ContinueLimit:        getEnvInt("CONTINUE_LIMIT", 20),
+		ShutdownTimeout:      getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		BindAddr:             bindAddr(getEnv("BIND_ADDR", ""), getEnv("PORT", "8080")),
+		TLSCert:              getEnv("TLS_CERT", ""),
+		TLSKey:               getEnv("TLS_KEY", ""),
+		UnixSocket:           getEnv("UNIX_SOCKET", ""),
+		AllowedOrigins:       parseOrigins(getEnv("ALLOWED_ORIGINS", "")),
+		CookieSecure:         getEnvBool("COOKIE_SECURE", false),
+		Hotness: HotnessConfig{
+			ViewWeight:   getEnvFloat("HOTNESS_VIEW_WEIGHT", 1.0),
+			LikeWeight:   getEnvFloat("HOTNESS_LIKE_WEIGHT", 5.0),
+			RecencyBonus: getEnvFloat("HOTNESS_RECENCY_BONUS", 70.0),
+			HalfLife:     getEnvDuration("HOTNESS_HALF_LIFE", 72*time.Hour),
+			ByWatchTime:  getEnvBool("HOTNESS_BY_WATCH_TIME", false),
+		},
+	}
+}
+
+// bindAddr adds port to addr unless it already has one, so BIND_ADDR can be
+// just an interface address like 192.168.1.10
+func bindAddr(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// parseExtensions parses a comma-separated extension list (e.g. ".mp4,.mkv,webm")
+// Extensions are lowercased and normalized to include the leading dot
+func parseExtensions(value string) []string {
+	var exts []string
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	if len(exts) == 0 {
+		return []string{".mp4"}
+	}
+	return exts
+}
+
+// parseOrigins parses a comma-separated origin list (e.g. "https://app.example.com,http://localhost:5173")
+// Trailing slashes are dropped, browsers send the Origin header without one
+func parseOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// parseThumbnailSizes parses a comma-separated list of name:width pairs
+// Invalid entries and the reserved name "large" are ignored
+func parseThumbnailSizes(value string) []ThumbnailSize {
+	var sizes []ThumbnailSize
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		width, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if name == "" || name == "large" || err != nil || width <= 0 {
+			continue
+		}
+		sizes = append(sizes, ThumbnailSize{Name: name, Width: width})
+	}
+	return sizes
+}
+
+// parseDirOverrides reads indexed per-directory values (e.g. THUMBNAIL_POSITION_1)
+// Indexes match VIDEO_DIR_N, so PREFIX_1 applies to the first video directory
+func parseDirOverrides(prefix string, count int) []string {
+	overrides := make([]string, count)
+	for i := range overrides {
+		overrides[i] = strings.TrimSpace(os.Getenv(fmt.Sprintf("%s_%d", prefix, i+1)))
 	}
+	return overrides
 }
 
 // parseVideoDirs parses video directories from environment variables
@@ -96,3 +299,39 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if intVal, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return intVal
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return fallback
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -8,24 +10,50 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// jwtSecret signs and verifies every token, set once by SetJWTSecret
 var jwtSecret []byte
 
+// SetJWTSecret sets the token signing key from the config
+// Called once at startup before the server runs, so handlers only read it
+func SetJWTSecret(cfg *config.Config) {
+	jwtSecret = []byte(cfg.JWTSecret)
+}
+
 // Claims represents JWT claims
 type Claims struct {
 	Username string `json:"username"`
+	Admin    bool   `json:"admin"`
+	Refresh  bool   `json:"refresh,omitempty"` // Set on refresh tokens, which can't be used as access tokens
+	Share    string `json:"share,omitempty"`   // Playlist ID of a read-only share token, the share ID is in ID
 	jwt.RegisteredClaims
 }
 
+// tokenCookie is the httpOnly cookie holding the access token, sent with page
+// loads and media requests that can't carry an Authorization header
+const tokenCookie = "token"
+
+// refreshCookie is the httpOnly cookie holding the refresh token
+const refreshCookie = "refresh_token"
+
+// setAuthCookie sets an httpOnly, SameSite=Lax cookie, Secure with COOKIE_SECURE
+// or when served over TLS. A negative maxAge deletes the cookie
+func setAuthCookie(c *gin.Context, cfg *config.Config, name, value string, maxAge int, path string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, path, "", cfg.CookieSecure || c.Request.TLS != nil, true)
+}
+
 // LoginPage renders login page
 func LoginPage(c *gin.Context) {
 	c.HTML(http.StatusOK, "login.html", nil)
 }
 
 // Login handles login request
-func Login(cfg *config.Config) gin.HandlerFunc {
-	jwtSecret = []byte(cfg.JWTSecret)
+// The account from AUTH_USER/AUTH_PASS is always an admin; other accounts come from the users table
+func Login(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			Username string `json:"username"`
@@ -37,45 +65,116 @@ func Login(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if req.Username != cfg.Username || req.Password != cfg.Password {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-			return
+		admin := checkCredentials(cfg, req.Username, req.Password)
+		if !admin {
+			user := store.AuthenticateUser(req.Username, req.Password)
+			if user == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+				return
+			}
+			admin = user.Admin
 		}
 
-		// Generate token
-		claims := Claims{
-			Username: req.Username,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-			},
+		issueTokens(c, cfg, req.Username, admin)
+	}
+}
+
+// RefreshHandler issues a new access token from the refresh token cookie
+// The refresh token is rotated as well, so active sessions stay logged in
+func RefreshHandler(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, err := c.Cookie(refreshCookie)
+		if err != nil || tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No refresh token provided", "reason": "missing"})
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString(jwtSecret)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		claims, err := parseClaims(tokenString)
+		if err != nil || !claims.Refresh {
+			setAuthCookie(c, cfg, refreshCookie, "", -1, "/api/refresh")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token", "reason": tokenErrorReason(err)})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"token": tokenString,
-			"username": req.Username,
-		})
+		issueTokens(c, cfg, claims.Username, claims.Admin)
+	}
+}
+
+// Logout clears the access and refresh token cookies
+// Tokens are stateless, so a copy kept elsewhere stays valid until it expires
+func Logout(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setAuthCookie(c, cfg, tokenCookie, "", -1, "/")
+		setAuthCookie(c, cfg, refreshCookie, "", -1, "/api/refresh")
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// issueTokens responds with a new access token and sets a new refresh token cookie
+func issueTokens(c *gin.Context, cfg *config.Config, username string, admin bool) {
+	tokenString, err := signToken(username, admin, false, cfg.TokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
+	refreshString, err := signToken(username, admin, true, cfg.RefreshTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Pages and media requests authenticate with the token cookie, API clients
+	// can use the token from the response instead
+	setAuthCookie(c, cfg, tokenCookie, tokenString, int(cfg.TokenTTL.Seconds()), "/")
+	// Only sent to the refresh endpoint and never readable by scripts
+	setAuthCookie(c, cfg, refreshCookie, refreshString, int(cfg.RefreshTokenTTL.Seconds()), "/api/refresh")
+
+	c.JSON(http.StatusOK, gin.H{
+		"token": tokenString,
+		"username": username,
+		"admin": admin,
+		"expiresIn": int(cfg.TokenTTL.Seconds()),
+	})
+}
+
+// signToken creates a signed JWT valid for ttl
+func signToken(username string, admin, refresh bool, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Username: username,
+		Admin:    admin,
+		Refresh:  refresh,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// checkCredentials verifies a username and password against the configured account
+// Uses the bcrypt hash from AUTH_PASS_HASH when set, the plaintext AUTH_PASS otherwise.
+// Comparisons are constant time so response timing doesn't leak how much matched
+func checkCredentials(cfg *config.Config, username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(cfg.Username)) == 1
+
+	var passOK bool
+	if cfg.PasswordHash != "" {
+		passOK = bcrypt.CompareHashAndPassword([]byte(cfg.PasswordHash), []byte(password)) == nil
+	} else {
+		passOK = subtle.ConstantTimeCompare([]byte(password), []byte(cfg.Password)) == 1
+	}
+
+	return userOK && passOK
 }
 
 // AuthMiddleware validates JWT token
 func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
-	jwtSecret = []byte(cfg.JWTSecret)
 	return func(c *gin.Context) {
 		// Check token in header or cookie
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			tokenString, _ = c.Cookie("token")
-		} else {
-			tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-		}
+		tokenString := tokenFromRequest(c)
 
 		if tokenString == "" {
 			// Redirect to login for page requests
@@ -84,25 +183,111 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 				c.Abort()
 				return
 			}
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided", "reason": "missing"})
 			c.Abort()
 			return
 		}
 
 		// Parse token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		claims, err := parseToken(tokenString)
+		if err != nil {
+			// "expired" tells the client to call /api/refresh rather than going back to login
+			reason := tokenErrorReason(err)
+			message := "Invalid token"
+			if reason == "expired" {
+				message = "Token expired"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": message, "reason": reason})
 			c.Abort()
 			return
 		}
 
+		if claims.Share != "" {
+			if !authorizeShare(c, claims) {
+				c.Abort()
+				return
+			}
+			c.Set("share", claims.Share)
+			c.Set("username", claims.Username) // The sharer, so smart playlists match what they see
+			c.Next()
+			return
+		}
+
 		// Set user info in context
 		c.Set("username", claims.Username)
+		c.Set("admin", claims.Admin)
 		c.Next()
 	}
 }
+
+// tokenFromRequest returns the JWT from the Authorization header, the token cookie
+// or the share cookie set by a share link
+func tokenFromRequest(c *gin.Context) string {
+	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
+		tokenString, _ = c.Cookie(tokenCookie)
+		if tokenString == "" {
+			tokenString, _ = c.Cookie(shareCookie)
+		}
+	} else {
+		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	}
+	return tokenString
+}
+
+// parseToken validates an access token and returns its claims
+func parseToken(tokenString string) (*Claims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Refresh {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// tokenErrorReason classifies a token error as "expired" or "invalid"
+func tokenErrorReason(err error) string {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return "expired"
+	}
+	return "invalid"
+}
+
+// parseClaims validates a JWT and returns its claims
+func parseClaims(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// NotFoundHandler handles unmatched routes
+// API requests get a JSON error, page requests get the 404 page (or the login page
+// when not authenticated), following the same Accept-header logic as AuthMiddleware
+func NotFoundHandler(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isPage := !strings.HasPrefix(c.Request.URL.Path, "/api/") &&
+			strings.HasPrefix(c.GetHeader("Accept"), "text/html")
+
+		if !isPage {
+			c.JSON(http.StatusNotFound, gin.H{"error": gin.H{"code": "not_found"}})
+			return
+		}
+
+		if _, err := parseToken(tokenFromRequest(c)); err != nil {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		c.HTML(http.StatusNotFound, "404.html", nil)
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// TestLogoutExpiresRefreshCookie guards against logging out only the access
+// token: login.html refreshes on load, so a surviving refresh_token cookie
+// signs the user straight back in
+func TestLogoutExpiresRefreshCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/logout", Logout(&config.Config{}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/logout", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	paths := map[string]string{tokenCookie: "/", refreshCookie: "/api/refresh"}
+	for _, cookie := range w.Result().Cookies() {
+		want, ok := paths[cookie.Name]
+		if !ok {
+			continue
+		}
+		delete(paths, cookie.Name)
+		if cookie.Path != want {
+			t.Errorf("%s cookie path = %q, want %q, the browser won't replace it", cookie.Name, cookie.Path, want)
+		}
+		if cookie.MaxAge >= 0 || cookie.Value != "" {
+			t.Errorf("%s cookie not expired: value %q, max age %d", cookie.Name, cookie.Value, cookie.MaxAge)
+		}
+	}
+	for name := range paths {
+		t.Errorf("logout didn't clear the %s cookie", name)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// backupDir returns the directory for saved and uploaded backups
+func backupDir(cfg *config.Config) string {
+	return filepath.Join(cfg.DataDir, "backups")
+}
+
+// backupName returns a timestamped backup file name that doesn't exist in dir yet
+func backupName(dir, prefix string) string {
+	stamp := time.Now().Format("20060102-150405")
+	name := fmt.Sprintf("%s-%s.db", prefix, stamp)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%s-%d.db", prefix, stamp, i)
+	}
+}
+
+// tempBackupPath reserves a unique path in dir that doesn't exist yet,
+// as VACUUM INTO refuses to overwrite files
+func tempBackupPath(dir, pattern string) (string, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	file.Close()
+	os.Remove(path)
+	return path, nil
+}
+
+// BackupHandler streams a consistent snapshot of the database as a download (admin only)
+// The backup includes password hashes, so it's restricted like user management.
+// With ?save=true a copy is also kept in DATA_DIR/backups.
+func BackupHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		dir := backupDir(cfg)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup directory"})
+			return
+		}
+
+		name := backupName(dir, "streamlet")
+		save, _ := strconv.ParseBool(c.Query("save"))
+		path := filepath.Join(dir, name)
+		if !save {
+			var err error
+			if path, err = tempBackupPath(dir, "download-*.db"); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup"})
+				return
+			}
+			defer os.Remove(path)
+		}
+
+		if err := store.Backup(path); err != nil {
+			log.Printf("❌ Backup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup"})
+			return
+		}
+		if save {
+			log.Printf("💾 Saved backup %s", path)
+		}
+
+		c.FileAttachment(path, name)
+	}
+}
+
+// RestoreHandler replaces the database contents with an uploaded backup (admin only)
+// The upload is validated and migrated first, and the current database is saved
+// to DATA_DIR/backups so the restore can be undone.
+func RestoreHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		upload, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Backup file is required"})
+			return
+		}
+
+		dir := backupDir(cfg)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup directory"})
+			return
+		}
+
+		uploadPath, err := tempBackupPath(dir, "upload-*.db")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload"})
+			return
+		}
+		defer os.Remove(uploadPath)
+		if err := c.SaveUploadedFile(upload, uploadPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload"})
+			return
+		}
+
+		if err := storage.ValidateBackup(uploadPath); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup: " + err.Error()})
+			return
+		}
+
+		safetyName := backupName(dir, "pre-restore")
+		if err := store.Backup(filepath.Join(dir, safetyName)); err != nil {
+			log.Printf("❌ Pre-restore backup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up current database"})
+			return
+		}
+
+		if err := store.Restore(uploadPath); err != nil {
+			log.Printf("❌ Restore failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore backup"})
+			return
+		}
+
+		log.Printf("💾 Restored database from %s (previous data saved as %s)", upload.Filename, safetyName)
+		c.JSON(http.StatusOK, gin.H{"message": "Database restored", "previousBackup": safetyName})
+	}
+}
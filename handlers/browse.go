@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// Folder is a subdirectory in a browse response
+type Folder struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`       // Relative to the video directory, for the next browse call
+	VideoCount int    `json:"videoCount"` // Videos in the folder and all of its subfolders
+}
+
+// BrowseResponse lists the contents of one folder in a video directory
+type BrowseResponse struct {
+	Dir     int      `json:"dir"`
+	Path    string   `json:"path"`
+	Parent  *string  `json:"parent"` // nil at the top of the video directory
+	Folders []Folder `json:"folders"`
+	Videos  []Video  `json:"videos"`
+}
+
+// cleanBrowsePath normalizes a relative folder path, "" being the top of the
+// video directory, and rejects paths that would leave it
+func cleanBrowsePath(value string) (string, bool) {
+	value = strings.ReplaceAll(value, "\\", "/")
+	if path.IsAbs(value) || filepath.IsAbs(value) {
+		return "", false
+	}
+	cleaned := path.Clean(value)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	if cleaned == "." {
+		cleaned = ""
+	}
+	return cleaned, true
+}
+
+// BrowseHandler returns the subfolders and videos at a path inside one video directory
+// Folders come from the index, so ones without any videos aren't listed.
+// Query parameters: dir (index or name, default the first directory) and path
+func BrowseHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dirIndex, ok := parseDirFilter(cfg, c.Query("dir"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown video directory"})
+			return
+		}
+		if dirIndex < 0 {
+			dirIndex = 0
+		}
+
+		relPath, ok := cleanBrowsePath(c.Query("path"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+			return
+		}
+
+		// Same containment check as streaming
+		absPath, err := filepath.Abs(filepath.Join(cfg.VideoDirs[dirIndex], filepath.FromSlash(relPath)))
+		if err != nil || !isInVideoDirs(cfg, absPath) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		if info, err := os.Stat(absPath); err != nil || !info.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		prefix := ""
+		if relPath != "" {
+			prefix = relPath + "/"
+		}
+
+		allStats := store.GetAllStats(c.GetString("username"))
+		allTags := store.GetAllVideoTags()
+
+		counts := make(map[string]int)
+		videos := make([]Video, 0)
+		for _, iv := range index.Videos() {
+			if iv.DirIndex != dirIndex {
+				continue
+			}
+			rel := filepath.ToSlash(strings.SplitN(iv.Path, ":", 2)[1])
+			if !strings.HasPrefix(rel, prefix) {
+				continue
+			}
+
+			rest := rel[len(prefix):]
+			if i := strings.Index(rest, "/"); i >= 0 {
+				counts[rest[:i]]++
+				continue
+			}
+
+			stats := allStats[iv.Path]
+			if stats == nil {
+				stats = &storage.VideoStats{}
+			}
+			tags := allTags[iv.Path]
+			if tags == nil {
+				tags = []string{}
+			}
+			video := newVideo(cfg, iv, stats, tags)
+			_, video.Subtitles = findSubtitle(iv.AbsPath)
+			videos = append(videos, video)
+		}
+
+		folders := make([]Folder, 0, len(counts))
+		for name, count := range counts {
+			folders = append(folders, Folder{Name: name, Path: prefix + name, VideoCount: count})
+		}
+		sort.Slice(folders, func(i, j int) bool {
+			return naturalCompare(folders[i].Name, folders[j].Name) < 0
+		})
+		sort.Slice(videos, func(i, j int) bool {
+			if c := naturalCompare(videos[i].Name, videos[j].Name); c != 0 {
+				return c < 0
+			}
+			return videos[i].Path < videos[j].Path
+		})
+
+		response := BrowseResponse{
+			Dir:     dirIndex,
+			Path:    relPath,
+			Folders: folders,
+			Videos:  videos,
+		}
+		if relPath != "" {
+			parent := path.Dir(relPath)
+			if parent == "." {
+				parent = ""
+			}
+			response.Parent = &parent
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// cacheMaxAge is how long clients may reuse a thumbnail or preview before revalidating
+// Kept short since regeneration rewrites the file under the same name
+const cacheMaxAge = 24 * 60 * 60
+
+// shardedLayout reports whether cache files are spread over subdirectories
+func shardedLayout(cfg *config.Config) bool {
+	return cfg.ThumbnailLayout == "sharded"
+}
+
+// cacheFile returns the path of a cached file named after a content hash
+// In the sharded layout files live in a subdirectory named by the first two
+// characters of the hash, like git objects, so no directory gets too large.
+func cacheFile(cfg *config.Config, name string) string {
+	if shardedLayout(cfg) {
+		if shard := cacheShard(name); shard != "" {
+			return filepath.Join(cfg.ThumbnailDir, shard, name)
+		}
+	}
+	return filepath.Join(cfg.ThumbnailDir, name)
+}
+
+// cacheShard returns the shard directory name of a cache file, empty if the
+// name doesn't start with a content hash
+func cacheShard(name string) string {
+	if hash := cacheFileHash(name); len(hash) >= 2 && isShardDir(hash[:2]) {
+		return hash[:2]
+	}
+	return ""
+}
+
+// isShardDir reports whether a directory in ThumbnailDir is a cache shard
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureCacheDir creates the directory a cache file is written to
+func ensureCacheDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// cacheEntry is a cache file found in ThumbnailDir
+type cacheEntry struct {
+	Name string // File name, starting with the content hash
+	Path string // Path relative to ThumbnailDir
+	Size int64
+}
+
+// listCacheFiles returns the cache files in ThumbnailDir and its shard directories,
+// whatever the configured layout
+func listCacheFiles(cfg *config.Config) ([]cacheEntry, error) {
+	entries, err := os.ReadDir(cfg.ThumbnailDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []cacheEntry
+	add := func(dir string, entry os.DirEntry) {
+		if info, err := entry.Info(); err == nil {
+			files = append(files, cacheEntry{Name: entry.Name(), Path: filepath.Join(dir, entry.Name()), Size: info.Size()})
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			add("", entry)
+			continue
+		}
+		if !isShardDir(entry.Name()) {
+			continue // covers, temp_* and anything else
+		}
+		shardEntries, err := os.ReadDir(filepath.Join(cfg.ThumbnailDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, shardEntry := range shardEntries {
+			if !shardEntry.IsDir() {
+				add(entry.Name(), shardEntry)
+			}
+		}
+	}
+	return files, nil
+}
+
+// MigrateCacheLayout moves cached thumbnails, previews and other per-hash files
+// to where the configured layout expects them, returning how many were moved
+// Runs at startup, so switching THUMBNAIL_LAYOUT either way keeps the cache.
+func MigrateCacheLayout(cfg *config.Config) (int, error) {
+	files, err := listCacheFiles(cfg)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, file := range files {
+		if cacheFileHash(file.Name) == "" {
+			continue
+		}
+		from := filepath.Join(cfg.ThumbnailDir, file.Path)
+		to := cacheFile(cfg, file.Name)
+		if from == to {
+			continue
+		}
+		if err := ensureCacheDir(to); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(from, to); err != nil {
+			log.Printf("⚠️  Failed to move %s: %v", file.Path, err)
+			continue
+		}
+		moved++
+	}
+
+	if !shardedLayout(cfg) {
+		// Remove the now empty shard directories
+		entries, _ := os.ReadDir(cfg.ThumbnailDir)
+		for _, entry := range entries {
+			if entry.IsDir() && isShardDir(entry.Name()) {
+				os.Remove(filepath.Join(cfg.ThumbnailDir, entry.Name()))
+			}
+		}
+	}
+	return moved, nil
+}
+
+// serveCacheFile sends a cached thumbnail or preview with an ETag, so clients
+// revalidate with If-None-Match and get a 304 instead of the whole file
+// The name identifies the video content and format, the modification time
+// changes when the file is regenerated.
+func serveCacheFile(c *gin.Context, path string) {
+	if info, err := os.Stat(path); err == nil {
+		c.Header("ETag", fmt.Sprintf(`"%s-%x"`, filepath.Base(path), info.ModTime().Unix()))
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", cacheMaxAge))
+	}
+	// http.ServeFile checks If-None-Match against the ETag header set above
+	c.File(path)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// Chapter is a chapter marker of a video
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"` // Seconds
+	End   float64 `json:"end"`   // Seconds
+}
+
+// chaptersFile returns the path of the cached chapter list of a content hash
+func chaptersFile(cfg *config.Config, hash string) string {
+	return cacheFile(cfg, hash+".chapters.json")
+}
+
+// probeChapters reads the chapter markers of a video using ffprobe, which
+// understands both MP4 (chpl and QuickTime chapter tracks) and MKV chapters
+func probeChapters(absPath string) ([]Chapter, error) {
+	cmd := ffprobeCommand(context.Background(),
+		"-v", "error",
+		"-show_chapters",
+		"-of", "json",
+		absPath,
+	)
+	output, err := outputFFmpeg(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Chapters []struct {
+			StartTime string            `json:"start_time"`
+			EndTime   string            `json:"end_time"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+
+	chapters := make([]Chapter, 0, len(probe.Chapters))
+	for i, ch := range probe.Chapters {
+		start, _ := strconv.ParseFloat(ch.StartTime, 64)
+		end, _ := strconv.ParseFloat(ch.EndTime, 64)
+		title := ch.Tags["title"]
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters = append(chapters, Chapter{Title: title, Start: start, End: end})
+	}
+	return chapters, nil
+}
+
+// GetChapters returns the chapter markers of a video for the player's seek bar,
+// an empty list for files without chapters
+// They're probed on first request and cached by content hash next to the thumbnail.
+func GetChapters(cfg *config.Config, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoPath := c.Query("video")
+		if videoPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No video specified"})
+			return
+		}
+
+		absVideoPath, err := parseVideoPath(videoPath, cfg)
+		if err == nil {
+			absVideoPath, err = filepath.Abs(absVideoPath)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video path"})
+			return
+		}
+
+		if !isInVideoDirs(cfg, absVideoPath) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		if _, err := os.Stat(absVideoPath); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+
+		hash, err := index.ContentHash(videoPath, absVideoPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
+			return
+		}
+
+		cachePath := chaptersFile(cfg, hash)
+		if data, err := os.ReadFile(cachePath); err == nil {
+			var chapters []Chapter
+			if json.Unmarshal(data, &chapters) == nil && chapters != nil {
+				c.JSON(http.StatusOK, gin.H{"video": videoPath, "chapters": chapters})
+				return
+			}
+		}
+
+		chapters, err := probeChapters(absVideoPath)
+		if err != nil {
+			requestLog(c).Error("❌ Failed to read chapters", "path", videoPath, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read chapters"})
+			return
+		}
+
+		// Cached even when empty, so files without chapters aren't probed every time
+		if err := writeChaptersCache(cachePath, chapters); err != nil {
+			requestLog(c).Warn("⚠️  Failed to cache chapters", "path", videoPath, "error", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"video": videoPath, "chapters": chapters})
+	}
+}
+
+// writeChaptersCache saves a chapter list, via a temp file so a concurrent
+// reader never sees a partial one
+func writeChaptersCache(path string, chapters []Chapter) error {
+	data, err := json.Marshal(chapters)
+	if err != nil {
+		return err
+	}
+	if err := ensureCacheDir(path); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// orphanFile is a cached thumbnail/preview file no video refers to
+type orphanFile struct {
+	Name string `json:"name"` // Relative to the thumbnail directory
+	Size int64  `json:"size"`
+}
+
+// cacheFileHash extracts the content hash from a thumbnail/preview filename
+// e.g. "<hash>.jpg", "<hash>_small.webp", "<hash>.preview.gif"
+func cacheFileHash(name string) string {
+	if i := strings.IndexAny(name, "._"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// staleTempDirAge is how long a temp_* directory must be untouched before
+// cleanup treats it as left over from a crashed run
+const staleTempDirAge = time.Hour
+
+// CleanupResult reports what a cleanup removed, or would remove in a dry run
+type CleanupResult struct {
+	DryRun       bool         `json:"dryRun"`
+	Count        int          `json:"count"` // Orphaned cache files
+	Bytes        int64        `json:"bytes"`
+	Files        []orphanFile `json:"files"`
+	TempDirs     int          `json:"tempDirs"`
+	TempDirBytes int64        `json:"tempDirBytes"`
+	TotalBytes   int64        `json:"totalBytes"`
+}
+
+// findOrphanedFiles lists files in the thumbnail directory whose content hash
+// isn't referenced by any thumbnail, preview or content hash in the database
+// An empty database references nothing, so nothing is reported as orphaned
+// rather than the whole cache; that's usually a fresh or replaced database.
+func findOrphanedFiles(cfg *config.Config, store *storage.Storage) ([]orphanFile, error) {
+	files, err := listCacheFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := store.GetReferencedHashes()
+	if len(referenced) == 0 {
+		return nil, nil
+	}
+
+	var orphans []orphanFile
+	for _, file := range files {
+		hash := cacheFileHash(file.Name)
+		if hash == "" || referenced[hash] {
+			continue
+		}
+		orphans = append(orphans, orphanFile{Name: file.Path, Size: file.Size})
+	}
+	return orphans, nil
+}
+
+// Cleanup deletes orphaned thumbnail/preview files and temp_* directories
+// not modified within tempDirAge
+func Cleanup(cfg *config.Config, store *storage.Storage, tempDirAge time.Duration, dryRun bool) (*CleanupResult, error) {
+	orphans, err := findOrphanedFiles(cfg, store)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CleanupResult{DryRun: dryRun, Files: make([]orphanFile, 0, len(orphans))}
+	for _, orphan := range orphans {
+		if !dryRun {
+			if err := os.Remove(filepath.Join(cfg.ThumbnailDir, orphan.Name)); err != nil {
+				continue
+			}
+		}
+		result.Files = append(result.Files, orphan)
+		result.Bytes += orphan.Size
+	}
+	result.Count = len(result.Files)
+
+	result.TempDirs, result.TempDirBytes = cleanupTempDirs(cfg, tempDirAge, dryRun)
+	result.TotalBytes = result.Bytes + result.TempDirBytes
+	return result, nil
+}
+
+// CleanupHandler deletes orphaned thumbnail and preview files and stale temp directories (admin only)
+// With ?dryRun=true it only reports what would be deleted
+func CleanupHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		dryRun := c.Query("dryRun") == "true"
+
+		result, err := Cleanup(cfg, store, staleTempDirAge, dryRun)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read thumbnail directory"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// ConfigHandler exposes the settings the UI needs to adapt itself
+func ConfigHandler(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sizes := []string{"large"}
+		for _, size := range cfg.ThumbnailSizes {
+			sizes = append(sizes, size.Name)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"previewsEnabled":       cfg.PreviewsEnabled,
+			"previewFormat":         cfg.PreviewFormat,
+			"thumbnailFormat":       cfg.ThumbnailFormat,
+			"thumbnailSizes":        sizes,
+			"videoExtensions":       cfg.VideoExtensions,
+			"watchThresholdSeconds": cfg.WatchThreshold.Seconds(),
+		})
+	}
+}
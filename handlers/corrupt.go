@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// validateTimeout bounds the ffprobe run that validates one file
+const validateTimeout = 30 * time.Second
+
+// validateVideo runs a quick ffprobe over the container, returning why the
+// file is unplayable, e.g. a truncated download missing its moov atom
+func validateVideo(absPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := ffprobeCommand(ctx,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		absPath,
+	)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+
+	message, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && message != "" {
+			return errors.New(message)
+		}
+		return err
+	}
+	if message != "" {
+		return errors.New(message)
+	}
+	if duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64); err != nil || duration <= 0 {
+		return fmt.Errorf("no duration")
+	}
+	return nil
+}
+
+// checkCorrupt returns the reason a file is quarantined as corrupt
+// A recorded failure holds while the file is unchanged. Other files are only
+// probed with cfg.ValidateVideos, since that's an extra ffprobe per file.
+func (idx *VideoIndex) checkCorrupt(vf videoFile) (string, bool) {
+	size, modTime := vf.Info.Size(), vf.Info.ModTime()
+	if record, ok := idx.store.GetCorruptVideo(vf.Path); ok {
+		if record.Matches(size, modTime) {
+			return record.Reason, true
+		}
+		// Replaced or repaired since, check it again
+		idx.store.ClearCorruptVideo(vf.Path)
+	}
+
+	if !idx.cfg.ValidateVideos || ffmpegMissing {
+		return "", false
+	}
+	if err := validateVideo(vf.AbsPath); err != nil {
+		slog.Warn("⚠️  Quarantined corrupt video", "path", vf.Path, "error", err)
+		idx.store.SetCorruptVideo(vf.Path, size, modTime, err.Error())
+		return err.Error(), true
+	}
+	return "", false
+}
+
+// quarantined returns the files of videos that are quarantined as corrupt, so
+// the generators skip them instead of failing on them every run
+func quarantined(store *storage.Storage, videos []videoFile) map[string]bool {
+	corrupt := store.GetCorruptVideos()
+	skip := make(map[string]bool)
+	for _, vf := range videos {
+		if record, ok := corrupt[vf.Path]; ok && record.Matches(vf.Info.Size(), vf.Info.ModTime()) {
+			skip[vf.Path] = true
+		}
+	}
+	return skip
+}
+
+// CorruptVideo is a quarantined video in the corrupt list
+type CorruptVideo struct {
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// CorruptVideosHandler lists the videos quarantined as corrupt, which the video
+// list leaves out unless asked with includeCorrupt=true
+func CorruptVideosHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		records := store.GetCorruptVideos()
+
+		videos := []CorruptVideo{}
+		for _, iv := range index.Videos() {
+			if iv.Corrupt == "" {
+				continue
+			}
+			video := CorruptVideo{Path: iv.Path, Name: iv.Name, Size: iv.Size, Reason: iv.Corrupt}
+			if record, ok := records[iv.Path]; ok {
+				video.DetectedAt = record.DetectedAt
+			}
+			videos = append(videos, video)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"total": len(videos), "videos": videos})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// CORS lets pages on cfg.AllowedOrigins call the /api routes, sending the
+// Authorization header and credentials. Preflight requests are answered here,
+// since the routes don't register OPTIONS. With no origins configured nothing
+// is added and browsers keep the API same-origin only.
+func CORS(cfg *config.Config) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(c *gin.Context) {
+		if len(allowed) == 0 || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		origin := c.GetHeader("Origin")
+		if origin == "" || !(allowed[origin] || allowed["*"]) {
+			c.Next()
+			return
+		}
+
+		// The origin is echoed even for "*", a literal wildcard isn't allowed with credentials
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+		header.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, ETag, X-Request-ID")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, HEAD")
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Range, If-None-Match, X-Request-ID")
+			header.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// Montage layout: 2x2 cells of 16:9 thumbnails
+const (
+	coverCellWidth  = 320
+	coverCellHeight = 180
+	coverTiles      = 4
+)
+
+// coverDir holds cached playlist covers
+// It's a subdirectory so orphan cleanup, which only looks at files, leaves it alone
+func coverDir(cfg *config.Config) string {
+	return filepath.Join(cfg.ThumbnailDir, "covers")
+}
+
+// playlistThumbnailHashes returns the thumbnail hashes of the first videos of a playlist
+// that already have a thumbnail, up to limit
+func playlistThumbnailHashes(cfg *config.Config, store *storage.Storage, videos []string, limit int) []string {
+	var hashes []string
+	for _, videoPath := range videos {
+		hash := store.GetThumbnailHash(videoPath)
+		if hash == "" {
+			continue
+		}
+		if _, err := os.Stat(thumbnailFile(cfg, hash, "", "jpg")); err != nil {
+			continue
+		}
+		hashes = append(hashes, hash)
+		if len(hashes) == limit {
+			break
+		}
+	}
+	return hashes
+}
+
+// PlaylistCoverHandler serves a playlist cover image
+// It is the thumbnail of the playlist's cover video when one is set, otherwise a 2x2
+// montage of the first four thumbnails. Montages are cached under a key derived from
+// the thumbnail hashes, so they are rebuilt whenever the member list changes
+func PlaylistCoverHandler(cfg *config.Config, store *storage.Storage, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		playlist := playlistStore.Get(c.Param("id"), c.GetString("username"))
+		if playlist == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+
+		webp := acceptsWebP(c, cfg)
+		c.Header("Vary", "Accept")
+
+		if playlist.CoverVideo != "" {
+			if hashes := playlistThumbnailHashes(cfg, store, []string{playlist.CoverVideo}, 1); len(hashes) == 1 {
+				serveCacheFile(c, selectThumbnail(cfg, hashes[0], "medium", webp))
+				return
+			}
+		}
+
+		hashes := playlistThumbnailHashes(cfg, store, playlist.Videos, coverTiles)
+		switch len(hashes) {
+		case 0:
+			c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnails available"})
+			return
+		case 1:
+			serveCacheFile(c, selectThumbnail(cfg, hashes[0], "medium", webp))
+			return
+		}
+
+		sum := sha1.Sum([]byte(strings.Join(hashes, ",")))
+		key := playlist.ID + "-" + hex.EncodeToString(sum[:8])
+		coverPath := filepath.Join(coverDir(cfg), key+".jpg")
+
+		if _, err := os.Stat(coverPath); err != nil {
+			if err := buildMontage(cfg, hashes, coverPath); err != nil {
+				log.Printf("❌ Failed to build cover for playlist %s: %v", playlist.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cover"})
+				return
+			}
+			removeStaleCovers(cfg, playlist.ID, coverPath)
+		}
+
+		c.File(coverPath)
+	}
+}
+
+// buildMontage composes up to four thumbnails into a 2x2 JPEG
+// With fewer thumbnails the cells repeat them so the grid is always full
+func buildMontage(cfg *config.Config, hashes []string, outputPath string) error {
+	montage := image.NewRGBA(image.Rect(0, 0, 2*coverCellWidth, 2*coverCellHeight))
+
+	for i := 0; i < coverTiles; i++ {
+		src, err := decodeJPEG(thumbnailFile(cfg, hashes[i%len(hashes)], "", "jpg"))
+		if err != nil {
+			return err
+		}
+		x := (i % 2) * coverCellWidth
+		y := (i / 2) * coverCellHeight
+		drawCover(montage, image.Rect(x, y, x+coverCellWidth, y+coverCellHeight), src)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so a concurrent request never serves a partial image
+	tmpPath := outputPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(file, montage, &jpeg.Options{Quality: 85}); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// decodeJPEG reads a JPEG image from disk
+func decodeJPEG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return jpeg.Decode(file)
+}
+
+// drawCover scales src to fill rect, cropping the overflow evenly (like CSS object-fit: cover)
+// Each destination pixel averages the block of source pixels it covers
+func drawCover(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	scale := max(float64(rect.Dx())/float64(sb.Dx()), float64(rect.Dy())/float64(sb.Dy()))
+	// Source region that maps onto rect
+	cropW := float64(rect.Dx()) / scale
+	cropH := float64(rect.Dy()) / scale
+	offX := float64(sb.Min.X) + (float64(sb.Dx())-cropW)/2
+	offY := float64(sb.Min.Y) + (float64(sb.Dy())-cropH)/2
+
+	for y := 0; y < rect.Dy(); y++ {
+		y0 := int(offY + float64(y)/scale)
+		y1 := max(int(offY+float64(y+1)/scale), y0+1)
+		for x := 0; x < rect.Dx(); x++ {
+			x0 := int(offX + float64(x)/scale)
+			x1 := max(int(offX+float64(x+1)/scale), x0+1)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1 && sy < sb.Max.Y; sy++ {
+				for sx := x0; sx < x1 && sx < sb.Max.X; sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+
+			i := dst.PixOffset(rect.Min.X+x, rect.Min.Y+y)
+			dst.Pix[i] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+}
+
+// removeStaleCovers deletes older cached covers of a playlist
+func removeStaleCovers(cfg *config.Config, playlistID, keep string) {
+	matches, _ := filepath.Glob(filepath.Join(coverDir(cfg), playlistID+"-*.jpg"))
+	for _, path := range matches {
+		if path != keep {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// listCursor is the position after the last video of a page, encoded as an
+// opaque token. It holds the video's sort keys rather than an offset, so the
+// next page starts in the right place even if videos were added or removed.
+type listCursor struct {
+	Sort         string  `json:"s"`
+	Order        string  `json:"o"`
+	Path         string  `json:"p"`
+	Name         string  `json:"n,omitempty"`
+	Modified     string  `json:"m,omitempty"`
+	Added        string  `json:"a,omitempty"`
+	Views        int     `json:"v,omitempty"`
+	Likes        int     `json:"l,omitempty"`
+	Hotness      float64 `json:"h,omitempty"`
+	Size         int64   `json:"z,omitempty"`
+	DurationSec  int     `json:"d,omitempty"`
+	WatchSeconds int     `json:"w,omitempty"`
+	Relevance    float64 `json:"r,omitempty"`
+	Bookmarked   bool    `json:"b,omitempty"`
+}
+
+var errInvalidCursor = errors.New("malformed token")
+
+// encodeCursor returns the token for continuing after v
+func encodeCursor(v *Video, sortBy, order string) string {
+	data, _ := json.Marshal(listCursor{
+		Sort:         sortBy,
+		Order:        order,
+		Path:         v.Path,
+		Name:         v.Name,
+		Modified:     v.Modified,
+		Added:        v.Added,
+		Views:        v.Views,
+		Likes:        v.Likes,
+		Hotness:      v.Hotness,
+		Size:         v.Size,
+		DurationSec:  v.DurationSec,
+		WatchSeconds: v.WatchSeconds,
+		Relevance:    v.Relevance,
+		Bookmarked:   v.Bookmarked,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a token from encodeCursor, which must have been issued
+// for the same sort and order
+func decodeCursor(token, sortBy, order string) (*Video, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cur listCursor
+	if err := json.Unmarshal(data, &cur); err != nil || cur.Path == "" {
+		return nil, errInvalidCursor
+	}
+	if cur.Sort != sortBy || cur.Order != order {
+		return nil, errors.New("cursor was issued for a different sort order")
+	}
+	return &Video{
+		Path:         cur.Path,
+		Name:         cur.Name,
+		Modified:     cur.Modified,
+		Added:        cur.Added,
+		Views:        cur.Views,
+		Likes:        cur.Likes,
+		Hotness:      cur.Hotness,
+		Size:         cur.Size,
+		DurationSec:  cur.DurationSec,
+		WatchSeconds: cur.WatchSeconds,
+		Relevance:    cur.Relevance,
+		Bookmarked:   cur.Bookmarked,
+	}, nil
+}
+
+// cursorPage returns the bounds of the page of sorted videos that follows after,
+// or the first page when after is nil, plus whether more videos follow it
+func cursorPage(videos []Video, after *Video, compare videoCompare, pageSize int) (int, int, bool) {
+	start := 0
+	if after != nil {
+		start = sort.Search(len(videos), func(i int) bool {
+			return compare(&videos[i], after) > 0
+		})
+	}
+	end := start + pageSize
+	if end >= len(videos) {
+		return start, len(videos), false
+	}
+	return start, end, true
+}
@@ -1,14 +1,55 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/abema/go-mp4"
 )
 
-// GetMP4Duration returns the duration of an MP4 file
+// GetVideoDuration returns the duration of a video file
+// ISO base media files (MP4, M4V, QuickTime MOV) are parsed natively,
+// other containers fall back to ffprobe
+func GetVideoDuration(filePath string) (time.Duration, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp4", ".m4v", ".mov":
+		// QuickTime and M4V share the moov/mvhd box layout with MP4
+		if duration, err := GetMP4Duration(filePath); err == nil && duration > 0 {
+			return duration, nil
+		}
+		// Fragmented MP4s leave the mvhd duration at 0 and keep the real
+		// length in their fragments, which ffprobe adds up
+	}
+	return getFFprobeDuration(filePath)
+}
+
+// getFFprobeDuration returns the duration of any container ffprobe understands
+func getFFprobeDuration(filePath string) (time.Duration, error) {
+	cmd := ffprobeCommand(context.Background(),
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run ffprobe: %w", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse duration: %w", err)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// GetMP4Duration returns the duration of an MP4, M4V or QuickTime MOV file
 func GetMP4Duration(filePath string) (time.Duration, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -28,7 +69,7 @@ func GetMP4Duration(filePath string) (time.Duration, error) {
 			if err != nil {
 				return nil, err
 			}
-			if mvhd, ok := box.(*mp4.Mvhd); ok {
+			if mvhd, ok := box.(*mp4.Mvhd); ok && mvhd.Timescale > 0 {
 				durationSeconds := float64(mvhd.GetDuration()) / float64(mvhd.Timescale)
 				duration = time.Duration(durationSeconds * float64(time.Second))
 				return duration, nil
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetMP4DurationQuickTimeAndM4V(t *testing.T) {
+	tests := []struct {
+		file string
+		want time.Duration
+	}{
+		{"sample.mov", 12500 * time.Millisecond}, // Version 0 mvhd, 600 units per second
+		{"sample.m4v", 83 * time.Second},         // Version 1 mvhd, 90kHz with a 64-bit duration
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := GetMP4Duration(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("GetMP4Duration: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("duration = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// eventHeartbeatInterval is how often an idle event stream sends a comment
+// so proxies don't time out the connection
+const eventHeartbeatInterval = 15 * time.Second
+
+// ProgressEvent is a generation progress update sent to event stream clients
+type ProgressEvent struct {
+	Total     int    `json:"total"`
+	Done      int    `json:"done"`
+	Failed    int    `json:"failed"`
+	Current   string `json:"current,omitempty"` // Video that was just processed
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// ProgressBroadcaster fans generation progress out to any number of event stream clients
+type ProgressBroadcaster struct {
+	mu      sync.Mutex
+	clients map[chan ProgressEvent]struct{}
+	last    ProgressEvent
+	running bool
+}
+
+// NewProgressBroadcaster creates a broadcaster with no clients
+func NewProgressBroadcaster() *ProgressBroadcaster {
+	return &ProgressBroadcaster{clients: make(map[chan ProgressEvent]struct{})}
+}
+
+// Start marks a generation job as running and resets the last progress
+func (b *ProgressBroadcaster) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = true
+	b.last = ProgressEvent{}
+}
+
+// Publish sends a progress update to all clients
+// Slow clients miss intermediate updates rather than blocking generation
+func (b *ProgressBroadcaster) Publish(ev ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = ev
+	for ch := range b.clients {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Finish marks the job as finished and closes all client channels,
+// which makes each stream send its final "done" event
+func (b *ProgressBroadcaster) Finish(cancelled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = false
+	b.last.Cancelled = cancelled
+	for ch := range b.clients {
+		close(ch)
+		delete(b.clients, ch)
+	}
+}
+
+// subscribe registers a client, returning nil if no job is running
+func (b *ProgressBroadcaster) subscribe() (chan ProgressEvent, ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		return nil, b.last
+	}
+	ch := make(chan ProgressEvent, 16)
+	b.clients[ch] = struct{}{}
+	return ch, b.last
+}
+
+// unsubscribe removes a client that disconnected before the job finished
+func (b *ProgressBroadcaster) unsubscribe(ch chan ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// lastEvent returns the most recent progress
+func (b *ProgressBroadcaster) lastEvent() ProgressEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// ProgressEventsHandler streams generation progress as Server-Sent Events
+// A "progress" event is sent for each update and a final "done" event when
+// the job finishes; if nothing is running, "done" is sent immediately
+func ProgressEventsHandler(cfg *config.Config, b *ProgressBroadcaster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // Disable nginx response buffering
+
+		ch, last := b.subscribe()
+		if ch == nil {
+			c.SSEvent("done", last)
+			return
+		}
+		defer b.unsubscribe(ch)
+
+		// Send the current state so clients don't wait for the next video
+		c.SSEvent("progress", last)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					c.SSEvent("done", b.lastEvent())
+					return false
+				}
+				c.SSEvent("progress", ev)
+			case <-heartbeat.C:
+				io.WriteString(w, ": heartbeat\n\n")
+			case <-c.Request.Context().Done():
+				return false
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// libraryResolver matches exported videos to the current library, by content
+// hash when exactly one video has it and by path otherwise
+func libraryResolver(idx *VideoIndex) storage.VideoResolver {
+	byHash := make(map[string][]string)
+	for _, v := range idx.Videos() {
+		if v.Hash != "" {
+			byHash[v.Hash] = append(byHash[v.Hash], v.Path)
+		}
+	}
+
+	return func(hash, path string) (string, bool, bool) {
+		if paths := byHash[hash]; hash != "" && len(paths) == 1 {
+			return paths[0], true, true
+		}
+		if idx.Get(path) != nil {
+			return path, false, true
+		}
+		return "", false, false
+	}
+}
+
+// ExportHandler downloads stats, tags and playlists as portable JSON (admin only)
+func ExportHandler(cfg *config.Config, store *storage.Storage, idx *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		data, err := store.Export(func(path string) string {
+			if v := idx.Get(path); v != nil {
+				return v.Hash
+			}
+			return ""
+		})
+		if err != nil {
+			log.Printf("❌ Export failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+			return
+		}
+
+		name := fmt.Sprintf("streamlet-export-%s.json", time.Now().Format("20060102-150405"))
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		c.JSON(http.StatusOK, data)
+	}
+}
+
+// ImportHandler merges an export into the database (admin only)
+// With ?dryRun=true nothing is changed and the report shows what would be
+func ImportHandler(cfg *config.Config, store *storage.Storage, idx *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		var data storage.ExportData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export file"})
+			return
+		}
+		if data.Version != storage.ExportVersion {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported export version %d", data.Version)})
+			return
+		}
+
+		dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+		report, err := store.Import(&data, libraryResolver(idx), dryRun)
+		if err != nil {
+			log.Printf("❌ Import failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data"})
+			return
+		}
+
+		if !dryRun {
+			log.Printf("📥 Imported %d videos (%d by hash, %d by path, %d unmatched), %d playlists created, %d merged",
+				report.VideosUpdated, report.MatchedByHash, report.MatchedByPath, len(report.Unmatched),
+				report.PlaylistsCreated, report.PlaylistsMerged)
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// Binaries and extra global ffmpeg arguments used for every call, set from the
+// config by CheckFFmpeg
+var (
+	ffmpegBin  = "ffmpeg"
+	ffprobeBin = "ffprobe"
+	ffmpegArgs []string
+)
+
+// execCommand creates the processes, replaceable to fake ffmpeg
+var execCommand = exec.CommandContext
+
+// ffmpegCommand returns an ffmpeg command with the configured binary, the
+// extra global arguments (e.g. -hwaccel cuda) first and then args
+func ffmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return execCommand(ctx, ffmpegBin, append(slices.Clone(ffmpegArgs), args...)...)
+}
+
+// ffprobeCommand returns an ffprobe command with the configured binary
+func ffprobeCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return execCommand(ctx, ffprobeBin, args...)
+}
+
+// ffmpegStderrLimit bounds how much of ffmpeg's stderr is kept for errors
+// The banner and progress come first, the reason for a failure is at the end.
+const ffmpegStderrLimit = 4096
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, starting at a line boundary when it was cut
+func (t *tailBuffer) String() string {
+	out := t.buf
+	if len(out) == t.limit {
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ffmpegError is a failed ffmpeg or ffprobe run with the end of its stderr
+type ffmpegError struct {
+	err    error
+	stderr string
+}
+
+func (e *ffmpegError) Error() string {
+	if e.stderr == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.err, e.stderr)
+}
+
+func (e *ffmpegError) Unwrap() error { return e.err }
+
+// runFFmpeg runs cmd, returning an *ffmpegError with the tail of its stderr if it fails
+func runFFmpeg(cmd *exec.Cmd) error {
+	stderr := &tailBuffer{limit: ffmpegStderrLimit}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return &ffmpegError{err: err, stderr: stderr.String()}
+	}
+	return nil
+}
+
+// outputFFmpeg runs cmd and returns its stdout, like runFFmpeg for probes
+func outputFFmpeg(cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runFFmpeg(cmd); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// ffmpegMissing is set at startup when ffmpeg or ffprobe couldn't be run
+var ffmpegMissing bool
+
+// FFmpegVersions are the version lines reported by the detected binaries
+type FFmpegVersions struct {
+	FFmpeg  string
+	FFprobe string
+}
+
+// binaryVersion runs "<bin> -version" and returns the first line of its output
+func binaryVersion(bin string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := execCommand(ctx, bin, "-version").Output()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(bytes.TrimSpace(out)), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// CheckFFmpeg makes every call use cfg.FFmpegPath, cfg.FFprobePath and
+// cfg.FFmpegArgs and checks that both binaries run, so a missing install is
+// reported once at startup instead of as a failure for every video
+func CheckFFmpeg(cfg *config.Config) (FFmpegVersions, error) {
+	ffmpegBin, ffprobeBin = cfg.FFmpegPath, cfg.FFprobePath
+	ffmpegArgs = cfg.FFmpegArgs
+
+	var versions FFmpegVersions
+	var missing []string
+	var err error
+	if versions.FFmpeg, err = binaryVersion(ffmpegBin); err != nil {
+		missing = append(missing, fmt.Sprintf("%s (%v)", ffmpegBin, err))
+	}
+	if versions.FFprobe, err = binaryVersion(ffprobeBin); err != nil {
+		missing = append(missing, fmt.Sprintf("%s (%v)", ffprobeBin, err))
+	}
+
+	ffmpegMissing = len(missing) > 0
+	if ffmpegMissing {
+		return versions, fmt.Errorf("can't run %s; install ffmpeg or set FFMPEG_PATH/FFPROBE_PATH", strings.Join(missing, ", "))
+	}
+	return versions, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// videoFilter is the set of query filters shared by the video list and the
+// random video endpoint
+type videoFilter struct {
+	dir            int      // Index into cfg.VideoDirs, -1 for all
+	tags           []string // Required tags
+	anyTag         bool     // Match any of tags instead of all
+	minSec         int      // Minimum duration in seconds
+	maxSec         int      // Maximum duration in seconds (exclusive), 0 means no limit
+	minViews       int      // Minimum total views
+	maxViews       int      // Maximum total views (exclusive), 0 means no limit
+	minLikes       int      // Minimum likes
+	maxLikes       int      // Maximum likes (exclusive), 0 means no limit
+	resolution     string   // e.g. 1080p, empty for any
+	bookmarked     bool     // Only the user's bookmarked videos
+	liked          bool     // Only videos the user liked
+	includeCorrupt bool     // Include videos quarantined as corrupt
+}
+
+// parseVideoFilter reads the filters from the query: dir, tags, tagMode,
+// durationMin and durationMax (minutes), viewsMin and viewsMax, likesMin and
+// likesMax, resolution, bookmarked, liked and includeCorrupt
+// Like durations, the maximums are exclusive, so viewsMax=1 finds unwatched videos.
+// ok is false when dir doesn't name a video directory
+func parseVideoFilter(c *gin.Context, cfg *config.Config) (videoFilter, bool) {
+	durationMin, _ := strconv.Atoi(c.DefaultQuery("durationMin", "0"))
+	durationMax, _ := strconv.Atoi(c.DefaultQuery("durationMax", "0"))
+	viewsMin, _ := strconv.Atoi(c.DefaultQuery("viewsMin", "0"))
+	viewsMax, _ := strconv.Atoi(c.DefaultQuery("viewsMax", "0"))
+	likesMin, _ := strconv.Atoi(c.DefaultQuery("likesMin", "0"))
+	likesMax, _ := strconv.Atoi(c.DefaultQuery("likesMax", "0"))
+	dir, ok := parseDirFilter(cfg, c.Query("dir"))
+	return videoFilter{
+		dir:            dir,
+		tags:           parseTagList(c.Query("tags")),
+		anyTag:         c.DefaultQuery("tagMode", "all") == "any",
+		minSec:         durationMin * 60,
+		maxSec:         durationMax * 60,
+		minViews:       viewsMin,
+		maxViews:       viewsMax,
+		minLikes:       likesMin,
+		maxLikes:       likesMax,
+		resolution:     normalizeResolution(c.Query("resolution")),
+		bookmarked:     c.Query("bookmarked") == "true",
+		liked:          c.Query("liked") == "true",
+		includeCorrupt: c.Query("includeCorrupt") == "true", // quarantined files are hidden by default
+	}, ok
+}
+
+// matchIndexed applies the filters that only need the index entry, the cheap
+// ones worth checking before building the Video
+func (f videoFilter) matchIndexed(iv *IndexedVideo) bool {
+	if f.dir >= 0 && iv.DirIndex != f.dir {
+		return false
+	}
+	return iv.Corrupt == "" || f.includeCorrupt
+}
+
+// match applies the remaining filters to a video built with newVideo
+func (f videoFilter) match(v *Video) bool {
+	if len(f.tags) > 0 && !matchTags(v.Tags, f.tags, f.anyTag) {
+		return false
+	}
+	if f.resolution != "" && v.Resolution != f.resolution {
+		return false
+	}
+	if !inRange(v.DurationSec, f.minSec, f.maxSec) || !inRange(v.Views, f.minViews, f.maxViews) || !inRange(v.Likes, f.minLikes, f.maxLikes) {
+		return false
+	}
+	if f.liked && !v.Liked {
+		return false
+	}
+	return !f.bookmarked || v.Bookmarked
+}
+
+// inRange reports whether low <= n < high, where a high of 0 means no upper bound
+func inRange(n, low, high int) bool {
+	return n >= low && (high <= 0 || n < high)
+}
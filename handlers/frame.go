@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"image/jpeg"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// smartCandidates are the positions (fraction of duration) sampled in smart mode
+var smartCandidates = []float64{0.10, 0.25, 0.40, 0.55, 0.70, 0.85}
+
+// isSmartPosition reports whether the thumbnail position selects smart mode
+func isSmartPosition(position string) bool {
+	return strings.EqualFold(strings.TrimSpace(position), "smart")
+}
+
+// thumbnailPosition returns the thumbnail position for a video,
+// preferring the override for the directory it came from
+func thumbnailPosition(cfg *config.Config, absVideoPath string) string {
+	if dirIndex, ok := videoDirIndex(cfg, absVideoPath); ok && dirIndex < len(cfg.ThumbnailDirPositions) {
+		if position := cfg.ThumbnailDirPositions[dirIndex]; position != "" {
+			return position
+		}
+	}
+	return cfg.ThumbnailPosition
+}
+
+// validThumbnailPosition reports whether a position is seconds, a percentage or smart
+func validThumbnailPosition(position string) bool {
+	position = strings.TrimSpace(position)
+	if isSmartPosition(position) {
+		return true
+	}
+	if strings.HasSuffix(position, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(position, "%"), 64)
+		return err == nil && pct >= 0 && pct <= 100
+	}
+	seconds, err := strconv.ParseFloat(position, 64)
+	return err == nil && seconds >= 0
+}
+
+// thumbnailTimestamp resolves a thumbnail position against a video duration
+// Supports a percentage ("10%"), absolute seconds ("95.5"), or empty for the middle
+func thumbnailTimestamp(position string, duration float64) float64 {
+	position = strings.TrimSpace(position)
+
+	if strings.HasSuffix(position, "%") {
+		if pct, err := strconv.ParseFloat(strings.TrimSuffix(position, "%"), 64); err == nil && pct >= 0 && pct <= 100 {
+			return duration * pct / 100
+		}
+	} else if seconds, err := strconv.ParseFloat(position, 64); err == nil && seconds >= 0 {
+		// Don't seek past the end on short videos
+		if seconds < duration {
+			return seconds
+		}
+	}
+
+	return duration / 2
+}
+
+// extractFrame writes a single JPEG frame at the given timestamp
+func extractFrame(ctx context.Context, videoPath string, timestamp float64, outputPath string) error {
+	cmd := ffmpegCommand(ctx,
+		"-i", videoPath,
+		"-ss", fmt.Sprintf("%.2f", timestamp), // Seek to timestamp
+		"-vframes", "1",                       // Extract one frame
+		"-q:v", "2",                           // High quality
+		"-y",                                  // Overwrite output file
+		outputPath,
+	)
+	return runFFmpeg(cmd)
+}
+
+// extractSmartFrame samples several candidate frames and keeps the most detailed one
+// Frames are scored by luma variance, with near-black and near-white frames penalized,
+// which avoids picking fades and scene transitions
+func extractSmartFrame(ctx context.Context, videoPath string, duration float64, outputPath string) error {
+	bestScore := -1.0
+	bestPath := ""
+
+	var candidates []string
+	defer func() {
+		for _, path := range candidates {
+			os.Remove(path)
+		}
+	}()
+
+	for i, fraction := range smartCandidates {
+		candidatePath := fmt.Sprintf("%s.candidate%d.jpg", outputPath, i)
+		if err := extractFrame(ctx, videoPath, duration*fraction, candidatePath); err != nil {
+			continue
+		}
+		candidates = append(candidates, candidatePath)
+
+		score, err := frameScore(candidatePath)
+		if err != nil {
+			continue
+		}
+		if score > bestScore {
+			bestScore = score
+			bestPath = candidatePath
+		}
+	}
+
+	if bestPath == "" {
+		// Nothing usable, fall back to the middle frame
+		return extractFrame(ctx, videoPath, duration/2, outputPath)
+	}
+
+	return os.Rename(bestPath, outputPath)
+}
+
+// frameScore rates how interesting a JPEG frame is
+func frameScore(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	bounds := img.Bounds()
+	// Sample roughly 100x100 points regardless of resolution
+	stepX := bounds.Dx()/100 + 1
+	stepY := bounds.Dy()/100 + 1
+
+	var sum, sumSq, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// Rec. 601 luma, scaled to 0-255
+			luma := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			sum += luma
+			sumSq += luma * luma
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("empty frame")
+	}
+
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+
+	score := stddev
+	if mean < 20 || mean > 235 {
+		score /= 4
+	}
+	return score, nil
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// etaSampleSize is how many recently processed videos the ETA estimate is based on
+const etaSampleSize = 20
+
+// GenerationProgress is the progress of a thumbnail or preview generation job
+type GenerationProgress struct {
+	Total     int
+	Done      int
+	Failed    int
+	Running   bool
+	Cancelled bool
+}
+
+// batchGenerator is implemented by ThumbnailGenerator and PreviewGenerator
+type batchGenerator interface {
+	SetProgressCallback(cb ProgressCallback)
+	GenerateAll(ctx context.Context) error
+}
+
+// generationJob tracks one kind of API-triggered generation
+type generationJob struct {
+	name      string // "Preview" or "Thumbnail", used in messages
+	progress  GenerationProgress
+	cancel    context.CancelFunc
+	processed []time.Time // When recent videos finished, for the ETA
+	events    *ProgressBroadcaster
+	generator func(workers int) batchGenerator
+}
+
+// percent returns how much of the job is processed, 0-100
+func (j *generationJob) percent() float64 {
+	if j.progress.Total == 0 {
+		if j.progress.Running {
+			return 0
+		}
+		return 100
+	}
+	return float64(j.progress.Done+j.progress.Failed) * 100 / float64(j.progress.Total)
+}
+
+// remaining returns the number of videos not processed yet
+func (j *generationJob) remaining() int {
+	if !j.progress.Running {
+		return 0
+	}
+	return j.progress.Total - j.progress.Done - j.progress.Failed
+}
+
+// rate returns the recent throughput in videos per second, 0 if unknown
+func (j *generationJob) rate() float64 {
+	if len(j.processed) < 2 {
+		return 0
+	}
+	elapsed := j.processed[len(j.processed)-1].Sub(j.processed[0]).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(len(j.processed)-1) / elapsed
+}
+
+// GenerationManager runs API-triggered thumbnail and preview generation and
+// tracks their progress
+type GenerationManager struct {
+	mu         sync.Mutex
+	ctx        context.Context
+	wg         *sync.WaitGroup
+	previews   *generationJob
+	thumbnails *generationJob
+}
+
+// NewGenerationManager creates a manager whose jobs stop when ctx is cancelled
+// Running jobs are added to wg so shutdown can wait for them
+func NewGenerationManager(ctx context.Context, cfg *config.Config, store *storage.Storage, wg *sync.WaitGroup) *GenerationManager {
+	return &GenerationManager{
+		ctx: ctx,
+		wg:  wg,
+		previews: &generationJob{
+			name:   "Preview",
+			events: NewProgressBroadcaster(),
+			generator: func(workers int) batchGenerator {
+				return NewPreviewGenerator(cfg, store, workers)
+			},
+		},
+		thumbnails: &generationJob{
+			name: "Thumbnail",
+			generator: func(workers int) batchGenerator {
+				return NewThumbnailGenerator(cfg, store, workers)
+			},
+		},
+	}
+}
+
+// PreviewEvents returns the broadcaster for preview progress events
+func (m *GenerationManager) PreviewEvents() *ProgressBroadcaster {
+	return m.previews.events
+}
+
+// start launches a job in the background, returning false if it's already running
+func (m *GenerationManager) start(job *generationJob, workers int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.progress.Running {
+		return false
+	}
+
+	jobCtx, cancel := context.WithCancel(m.ctx)
+	job.progress = GenerationProgress{Running: true}
+	job.cancel = cancel
+	job.processed = nil
+	if job.events != nil {
+		job.events.Start()
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+
+		generator := job.generator(workers)
+		generator.SetProgressCallback(func(total, done, failed int, current string) {
+			m.mu.Lock()
+			job.progress.Total = total
+			job.progress.Done = done
+			job.progress.Failed = failed
+			job.processed = append(job.processed, time.Now())
+			if len(job.processed) > etaSampleSize {
+				job.processed = job.processed[len(job.processed)-etaSampleSize:]
+			}
+			m.mu.Unlock()
+			if job.events != nil {
+				job.events.Publish(ProgressEvent{Total: total, Done: done, Failed: failed, Current: current})
+			}
+		})
+		err := generator.GenerateAll(jobCtx)
+		cancelled := errors.Is(err, context.Canceled)
+
+		m.mu.Lock()
+		job.progress.Running = false
+		job.progress.Cancelled = cancelled
+		job.cancel = nil
+		m.mu.Unlock()
+		if job.events != nil {
+			job.events.Finish(cancelled)
+		}
+	}()
+	return true
+}
+
+// stop cancels a running job, returning false if it isn't running
+func (m *GenerationManager) stop(job *generationJob) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !job.progress.Running || job.cancel == nil {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// progress returns a snapshot of a job's progress
+func (m *GenerationManager) progress(job *generationJob) GenerationProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return job.progress
+}
+
+// GenerationJobStatus is one job in the combined generation status
+type GenerationJobStatus struct {
+	Total      int     `json:"total"`
+	Done       int     `json:"done"`
+	Failed     int     `json:"failed"`
+	Running    bool    `json:"running"`
+	Cancelled  bool    `json:"cancelled"`
+	Percent    float64 `json:"percent"`
+	EtaSeconds *int    `json:"etaSeconds"` // nil when not running or no throughput yet
+}
+
+// GenerationStatus is the combined status of thumbnail and preview generation
+type GenerationStatus struct {
+	Thumbnails GenerationJobStatus `json:"thumbnails"`
+	Previews   GenerationJobStatus `json:"previews"`
+	Running    bool                `json:"running"`
+	Percent    float64             `json:"percent"`
+	EtaSeconds *int                `json:"etaSeconds"`
+}
+
+// etaSeconds estimates the seconds needed to process remaining videos at rate
+func etaSeconds(remaining int, rate float64) *int {
+	if remaining <= 0 || rate <= 0 {
+		return nil
+	}
+	eta := int(math.Ceil(float64(remaining) / rate))
+	return &eta
+}
+
+// jobStatus builds the status of a single job; the caller holds m.mu
+func jobStatus(job *generationJob) GenerationJobStatus {
+	return GenerationJobStatus{
+		Total:      job.progress.Total,
+		Done:       job.progress.Done,
+		Failed:     job.progress.Failed,
+		Running:    job.progress.Running,
+		Cancelled:  job.progress.Cancelled,
+		Percent:    math.Round(job.percent()*10) / 10,
+		EtaSeconds: etaSeconds(job.remaining(), job.rate()),
+	}
+}
+
+// Status returns the combined status of both jobs
+// The aggregate percentage covers the running jobs; the aggregate ETA is the
+// longer of the two since they run concurrently
+func (m *GenerationManager) Status() GenerationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := GenerationStatus{
+		Thumbnails: jobStatus(m.thumbnails),
+		Previews:   jobStatus(m.previews),
+		Percent:    100,
+	}
+
+	total, processed := 0, 0
+	for _, job := range []*generationJob{m.thumbnails, m.previews} {
+		if !job.progress.Running {
+			continue
+		}
+		status.Running = true
+		total += job.progress.Total
+		processed += job.progress.Done + job.progress.Failed
+	}
+	if status.Running {
+		status.Percent = 0
+		if total > 0 {
+			status.Percent = math.Round(float64(processed)*1000/float64(total)) / 10
+		}
+	}
+	for _, eta := range []*int{status.Thumbnails.EtaSeconds, status.Previews.EtaSeconds} {
+		if eta != nil && (status.EtaSeconds == nil || *eta > *status.EtaSeconds) {
+			status.EtaSeconds = eta
+		}
+	}
+	return status
+}
+
+// generateHandler starts a generation job with an optional "workers" query parameter
+func generateHandler(cfg *config.Config, m *GenerationManager, job *generationJob) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ffmpegMissing {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ffmpeg is not available, check FFMPEG_PATH and FFPROBE_PATH"})
+			return
+		}
+
+		workers, ok := GenerationWorkers(c, cfg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("workers must be between 1 and %d", MaxGenerationWorkers)})
+			return
+		}
+
+		if !m.start(job, workers) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    job.name + " generation already running",
+				"progress": m.progress(job),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": job.name + " generation started", "workers": workers})
+	}
+}
+
+// cancelHandler stops a running generation job
+func cancelHandler(m *GenerationManager, job *generationJob) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.stop(job) {
+			c.JSON(http.StatusConflict, gin.H{"error": job.name + " generation is not running"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": job.name + " generation cancelling"})
+	}
+}
+
+// progressHandler returns the progress of a single generation job
+func progressHandler(m *GenerationManager, job *generationJob) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, m.progress(job))
+	}
+}
+
+// GeneratePreviewsHandler starts batch preview generation
+func GeneratePreviewsHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	generate := generateHandler(cfg, m, m.previews)
+	return func(c *gin.Context) {
+		if !cfg.PreviewsEnabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Preview generation is disabled"})
+			return
+		}
+		generate(c)
+	}
+}
+
+// GenerateThumbnailsHandler starts batch thumbnail generation
+func GenerateThumbnailsHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return generateHandler(cfg, m, m.thumbnails)
+}
+
+// CancelPreviewsHandler stops batch preview generation
+func CancelPreviewsHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return cancelHandler(m, m.previews)
+}
+
+// CancelThumbnailsHandler stops batch thumbnail generation
+func CancelThumbnailsHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return cancelHandler(m, m.thumbnails)
+}
+
+// PreviewStatusHandler returns batch preview generation progress
+func PreviewStatusHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return progressHandler(m, m.previews)
+}
+
+// ThumbnailStatusHandler returns batch thumbnail generation progress
+func ThumbnailStatusHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return progressHandler(m, m.thumbnails)
+}
+
+// GenerationStatusHandler returns the combined thumbnail and preview generation status
+func GenerationStatusHandler(cfg *config.Config, m *GenerationManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, m.Status())
+	}
+}
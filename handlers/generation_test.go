@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// finishedEvery returns n processing times step apart, giving a rate of one
+// video per step
+func finishedEvery(n int, step time.Duration) []time.Time {
+	start := time.Now()
+	times := make([]time.Time, n)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * step)
+	}
+	return times
+}
+
+func TestGenerationStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		thumbnails  generationJob
+		previews    generationJob
+		wantRunning bool
+		wantPercent float64
+		wantEta     int // 0 for none
+	}{
+		{
+			name:        "idle",
+			previews:    generationJob{progress: GenerationProgress{Total: 20, Done: 20}},
+			wantPercent: 100,
+		},
+		{
+			name: "finished jobs are left out",
+			thumbnails: generationJob{
+				progress:  GenerationProgress{Total: 100, Done: 40, Failed: 10, Running: true},
+				processed: finishedEvery(5, time.Second),
+			},
+			previews:    generationJob{progress: GenerationProgress{Total: 20, Done: 20}},
+			wantRunning: true,
+			wantPercent: 50,
+			wantEta:     50,
+		},
+		{
+			name: "both running takes the longer ETA",
+			thumbnails: generationJob{
+				progress:  GenerationProgress{Total: 100, Done: 25, Running: true},
+				processed: finishedEvery(5, time.Second),
+			},
+			previews: generationJob{
+				progress:  GenerationProgress{Total: 50, Done: 10, Failed: 5, Running: true},
+				processed: finishedEvery(5, 2*time.Second),
+			},
+			wantRunning: true,
+			wantPercent: 26.7,
+			wantEta:     75,
+		},
+		{
+			name:        "no throughput yet",
+			thumbnails:  generationJob{progress: GenerationProgress{Total: 3, Done: 1, Running: true}, processed: finishedEvery(1, time.Second)},
+			previews:    generationJob{progress: GenerationProgress{Running: true}},
+			wantRunning: true,
+			wantPercent: 33.3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &GenerationManager{thumbnails: &tt.thumbnails, previews: &tt.previews}
+			status := m.Status()
+
+			if status.Running != tt.wantRunning {
+				t.Errorf("running = %v, want %v", status.Running, tt.wantRunning)
+			}
+			if status.Percent != tt.wantPercent {
+				t.Errorf("percent = %v, want %v", status.Percent, tt.wantPercent)
+			}
+			switch {
+			case tt.wantEta == 0 && status.EtaSeconds != nil:
+				t.Errorf("eta = %d, want none", *status.EtaSeconds)
+			case tt.wantEta != 0 && status.EtaSeconds == nil:
+				t.Errorf("eta = none, want %d", tt.wantEta)
+			case tt.wantEta != 0 && *status.EtaSeconds != tt.wantEta:
+				t.Errorf("eta = %d, want %d", *status.EtaSeconds, tt.wantEta)
+			}
+		})
+	}
+}
+
+// blockingGenerator reports some progress and then runs until cancelled
+type blockingGenerator struct {
+	cb      ProgressCallback
+	started chan struct{}
+}
+
+func (g *blockingGenerator) SetProgressCallback(cb ProgressCallback) { g.cb = cb }
+
+func (g *blockingGenerator) GenerateAll(ctx context.Context) error {
+	g.cb(10, 3, 1, "0:a.mp4")
+	g.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGenerationCancel(t *testing.T) {
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	job := &generationJob{
+		name: "Thumbnail",
+		generator: func(workers int) batchGenerator {
+			return &blockingGenerator{started: started}
+		},
+	}
+	m := &GenerationManager{ctx: context.Background(), wg: &wg, thumbnails: job, previews: &generationJob{}}
+
+	if m.stop(job) {
+		t.Fatal("stopped a job that wasn't running")
+	}
+	if !m.start(job, 1) {
+		t.Fatal("start failed")
+	}
+	<-started
+	if m.start(job, 1) {
+		t.Error("started a job that was already running")
+	}
+	if got := m.progress(job); !got.Running || got.Cancelled || got.Done != 3 || got.Failed != 1 {
+		t.Errorf("progress while running = %+v", got)
+	}
+
+	if !m.stop(job) {
+		t.Fatal("stop failed")
+	}
+	wg.Wait()
+	if got := m.progress(job); got.Running || !got.Cancelled {
+		t.Errorf("progress after cancel = %+v, want cancelled and not running", got)
+	}
+	if m.stop(job) {
+		t.Error("stopped a job that was already cancelled")
+	}
+
+	// Starting again clears the cancelled state
+	if !m.start(job, 1) {
+		t.Fatal("restart failed")
+	}
+	<-started
+	if got := m.progress(job); !got.Running || got.Cancelled {
+		t.Errorf("progress after restart = %+v, want running and not cancelled", got)
+	}
+	m.stop(job)
+	wg.Wait()
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// ProgressCallback is called by the batch generators after each video is processed
+// current is the path of the video that was just processed
+type ProgressCallback func(total, done, failed int, current string)
+
+// MaxGenerationWorkers bounds the worker count of a generation job
+const MaxGenerationWorkers = 32
+
+// clampWorkers keeps a worker count within 1..MaxGenerationWorkers, using fallback when unset
+func clampWorkers(workers, fallback int) int {
+	if workers < 1 {
+		workers = fallback
+	}
+	if workers > MaxGenerationWorkers {
+		workers = MaxGenerationWorkers
+	}
+	return workers
+}
+
+// GenerationWorkers returns the worker count requested via the "workers" query
+// parameter, or cfg.GenerationWorkers when it's absent
+func GenerationWorkers(c *gin.Context, cfg *config.Config) (int, bool) {
+	value := c.Query("workers")
+	if value == "" {
+		return clampWorkers(cfg.GenerationWorkers, 4), true
+	}
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 || workers > MaxGenerationWorkers {
+		return 0, false
+	}
+	return workers, true
+}
+
+// ffmpegThreads returns the thread count for each preview encode. ffmpeg uses
+// every core by default, so running several workers at once would oversubscribe
+// the CPU; unless FFMPEG_THREADS is set, the cores are split across workers
+func ffmpegThreads(cfg *config.Config, workers int) int {
+	if cfg.FFmpegThreads > 0 {
+		return cfg.FFmpegThreads
+	}
+	threads := runtime.NumCPU() / workers
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// memoryPollInterval is how often a paused worker rechecks available memory
+const memoryPollInterval = 5 * time.Second
+
+// waitForMemory blocks while available memory is below cfg.MinFreeMemMB
+// It returns immediately when the guard is disabled or memory can't be read,
+// and false if ctx is cancelled, in which case no new work should start
+func waitForMemory(ctx context.Context, cfg *config.Config) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if cfg.MinFreeMemMB <= 0 {
+		return true
+	}
+
+	logged := false
+	for {
+		available, ok := availableMemoryMB()
+		if !ok || available >= cfg.MinFreeMemMB {
+			if logged {
+				slog.Info("✅ Available memory recovered, resuming generation", "available_mb", available)
+			}
+			return true
+		}
+		if !logged {
+			slog.Warn("⚠️  Available memory low, pausing generation", "available_mb", available, "min_free_mb", cfg.MinFreeMemMB)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(memoryPollInterval):
+		}
+	}
+}
+
+// generateWithTimeout runs one video's generation bounded by cfg.FFmpegTimeout
+// ffmpeg is started with the per-file context, so it's killed when the limit is
+// hit. With cfg.FFmpegRetry a failed or timed out file is tried once more.
+func generateWithTimeout(ctx context.Context, cfg *config.Config, videoPath string, generate func(context.Context) error) error {
+	attempts := 1
+	if cfg.FFmpegRetry {
+		attempts = 2
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		fileCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.FFmpegTimeout > 0 {
+			fileCtx, cancel = context.WithTimeout(ctx, cfg.FFmpegTimeout)
+		}
+		err = generate(fileCtx)
+		timedOut := errors.Is(fileCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if timedOut {
+			err = fmt.Errorf("timed out after %s: %w", cfg.FFmpegTimeout, err)
+		}
+		if attempt < attempts {
+			slog.Warn("⚠️  Generation failed, retrying", "path", videoPath, "error", err)
+		}
+	}
+	return err
+}
+
+// generationFailed responds with a 500 for a failed on-demand generation
+// Outside production the underlying error, which ends with ffmpeg's stderr, is
+// included so a failing file can be diagnosed from the client instead of the server logs.
+func generationFailed(c *gin.Context, cfg *config.Config, message string, err error) {
+	if errors.Is(err, errGenerationBusy) {
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many generations in progress, try again later"})
+		return
+	}
+	body := gin.H{"error": message}
+	if cfg.Env != "production" {
+		body["detail"] = err.Error()
+	}
+	c.JSON(http.StatusInternalServerError, body)
+}
+
+// TempDirSweepAge is how old a temp_* directory must be for the startup and
+// shutdown sweeps to remove it, so another instance sharing the thumbnail
+// directory isn't disturbed
+const TempDirSweepAge = 5 * time.Minute
+
+// SweepTempDirs removes temp_* directories left behind by a crash, kill or
+// interrupted generation, returning how many were removed and their size
+func SweepTempDirs(cfg *config.Config) (int, int64) {
+	return cleanupTempDirs(cfg, TempDirSweepAge, false)
+}
+
+// cleanupTempDirs removes temp_* directories not modified for at least olderThan,
+// returning how many were (or with dryRun would be) removed and their size
+// Generation writes into its temp dir continuously, so an age limit keeps
+// running jobs' directories safe
+func cleanupTempDirs(cfg *config.Config, olderThan time.Duration, dryRun bool) (int, int64) {
+	matches, _ := filepath.Glob(filepath.Join(cfg.ThumbnailDir, "temp_*"))
+	removed := 0
+	var bytes int64
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() || time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+		size := dirSize(path)
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				continue
+			}
+		}
+		removed++
+		bytes += size
+	}
+	return removed, bytes
+}
+
+// dirSize returns the total size of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// availableMemoryMB reads MemAvailable from /proc/meminfo (Linux only)
+func availableMemoryMB() (int64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb / 1024, true
+		}
+	}
+	return 0, false
+}
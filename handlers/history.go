@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// historyItem is a history entry with how long ago it was viewed
+type historyItem struct {
+	storage.HistoryEntry
+	AgoSeconds int64 `json:"agoSeconds"`
+}
+
+// HistoryHandler returns the user's watch history, most recent first
+// With ?events=true every view is listed instead of each video once
+func HistoryHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+		events := c.Query("events") == "true"
+
+		if page < 1 {
+			page = 1
+		}
+		if pageSize < 1 || pageSize > 100 {
+			pageSize = 50
+		}
+
+		entries, total := store.GetHistory(c.GetString("username"), events, (page-1)*pageSize, pageSize)
+
+		now := time.Now()
+		items := make([]historyItem, len(entries))
+		for i, entry := range entries {
+			items[i] = historyItem{
+				HistoryEntry: entry,
+				AgoSeconds:   int64(now.Sub(entry.ViewedAt).Seconds()),
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total":      total,
+			"page":       page,
+			"pageSize":   pageSize,
+			"totalPages": (total + pageSize - 1) / pageSize,
+			"history":    items,
+		})
+	}
+}
+
+// ClearHistoryHandler clears the user's watch history
+func ClearHistoryHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !store.ClearHistory(c.GetString("username")) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear history"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "History cleared"})
+	}
+}
+
+// continueItem is a video in the continue watching list
+type continueItem struct {
+	Video
+	LastWatched time.Time `json:"lastWatched"`
+}
+
+// ContinueWatchingHandler returns the videos the user started but didn't
+// finish, most recently watched first, up to cfg.ContinueLimit
+// Only positions past cfg.ContinueMinPosition count, so a few seconds of a
+// video opened by mistake don't put it on the list.
+func ContinueWatchingHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.GetString("username")
+		entries := store.GetInProgress(username, cfg.ContinueMinPosition.Seconds())
+
+		allStats := store.GetAllStats(username)
+		allTags := store.GetAllVideoTags()
+		items := []continueItem{}
+		for _, entry := range entries {
+			if len(items) >= cfg.ContinueLimit {
+				break
+			}
+			// Deleted and quarantined videos can't be resumed
+			iv := index.Get(entry.Path)
+			if iv == nil || iv.Corrupt != "" {
+				continue
+			}
+			video := newVideo(cfg, iv, videoStats(allStats, iv.Path), videoTags(allTags, iv.Path))
+			items = append(items, continueItem{Video: video, LastWatched: entry.LastWatched})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"total": len(items), "videos": items})
+	}
+}
+
+// DismissContinueHandler removes a video from continue watching by resetting
+// its playback position
+func DismissContinueHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoPath := c.Query("video")
+		if videoPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No video specified"})
+			return
+		}
+		store.SetPosition(videoPath, "", c.GetString("username"), 0)
+		c.JSON(http.StatusOK, gin.H{"message": "Removed from continue watching"})
+	}
+}
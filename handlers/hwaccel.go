@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// defaultVAAPIDevice is the render node used for VA-API when none is configured
+const defaultVAAPIDevice = "/dev/dri/renderD128"
+
+// videoEncoder builds the decode and encode arguments of a preview encode
+// for software x264 or one of the hardware encoders
+type videoEncoder struct {
+	hwaccel string // "none", "nvenc", "qsv" or "vaapi"
+	device  string // VA-API render node
+	threads int    // x264 threads
+}
+
+// softwareEncoder returns the libx264 encoder
+func softwareEncoder(threads int) videoEncoder {
+	return videoEncoder{hwaccel: "none", threads: threads}
+}
+
+// previewEncoder returns the encoder configured by cfg.HWAccel
+func previewEncoder(cfg *config.Config, threads int) videoEncoder {
+	switch cfg.HWAccel {
+	case "nvenc", "qsv", "vaapi":
+		device := cfg.HWAccelDevice
+		if device == "" {
+			device = defaultVAAPIDevice
+		}
+		return videoEncoder{hwaccel: cfg.HWAccel, device: device, threads: threads}
+	default:
+		return softwareEncoder(threads)
+	}
+}
+
+// hardware reports whether the encoder uses the GPU
+func (e videoEncoder) hardware() bool {
+	return e.hwaccel != "none"
+}
+
+// inputArgs returns the decode acceleration flags, placed before -i
+// Decoded frames are copied back to system memory, so files the hardware
+// can't decode still work through ffmpeg's software fallback.
+func (e videoEncoder) inputArgs() []string {
+	switch e.hwaccel {
+	case "nvenc":
+		return []string{"-hwaccel", "cuda"}
+	case "qsv":
+		return []string{"-hwaccel", "qsv"}
+	case "vaapi":
+		return []string{"-hwaccel", "vaapi", "-vaapi_device", e.device}
+	}
+	return nil
+}
+
+// outputArgs returns the encoder and its quality flags, mapping the x264 CRF
+// to each encoder's constant quality setting
+func (e videoEncoder) outputArgs(crf int, preset string) []string {
+	quality := strconv.Itoa(crf)
+	switch e.hwaccel {
+	case "nvenc":
+		return []string{"-c:v", "h264_nvenc", "-rc", "vbr", "-cq", quality, "-b:v", "0"}
+	case "qsv":
+		return []string{"-c:v", "h264_qsv", "-global_quality", quality}
+	case "vaapi":
+		return []string{"-vf", "format=nv12,hwupload", "-c:v", "h264_vaapi", "-qp", quality}
+	}
+	return []string{"-c:v", "libx264", "-crf", quality, "-preset", preset, "-threads", strconv.Itoa(e.threads)}
+}
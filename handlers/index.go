@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// IndexedVideo is a video entry cached by the VideoIndex
+type IndexedVideo struct {
+	Path     string                 // Prefixed path (dirIndex:relPath)
+	AbsPath  string                 // Path on disk
+	DirIndex int                    // Index into cfg.VideoDirs
+	Name     string                 // File name
+	Size     int64                  // File size in bytes
+	ModTime  time.Time              // File modification time
+	Duration time.Duration          // Video duration, 0 if unknown
+	Hash     string                 // Content hash (cfg.HashMode), empty if the file couldn't be read
+	Metadata *storage.MediaMetadata // Probed stream info, nil if unknown
+	Corrupt  string                 // Why the file failed validation, empty unless quarantined
+}
+
+// ScanResult summarizes a single index refresh
+type ScanResult struct {
+	Total    int           `json:"total"`
+	Added    int           `json:"added"`
+	Updated  int           `json:"updated"`
+	Removed  int           `json:"removed"`
+	Changed  int           `json:"changed"`
+	Duration time.Duration `json:"-"`
+	Took     string        `json:"took"`
+}
+
+// VideoIndex caches discovered videos so list requests don't walk the disk
+// Files are only re-probed when their size or modification time changes
+type VideoIndex struct {
+	cfg      *config.Config
+	store    *storage.Storage
+	mu       sync.RWMutex
+	videos   map[string]*IndexedVideo
+	scanned  bool
+	lastScan time.Time
+	scanMu   sync.Mutex // Serializes scans
+}
+
+// NewVideoIndex creates an empty video index
+func NewVideoIndex(cfg *config.Config, store *storage.Storage) *VideoIndex {
+	return &VideoIndex{
+		cfg:    cfg,
+		store:  store,
+		videos: make(map[string]*IndexedVideo),
+	}
+}
+
+// Run scans immediately and then refreshes the index every interval
+// An interval <= 0 disables periodic refresh after the initial scan
+func (idx *VideoIndex) Run(interval time.Duration) {
+	result := idx.Scan()
+	log.Printf("📇 Indexed %d videos in %s", result.Total, result.Took)
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result := idx.Scan()
+		if result.Changed > 0 {
+			log.Printf("📇 Index refreshed: %d added, %d updated, %d removed (%s)",
+				result.Added, result.Updated, result.Removed, result.Took)
+		}
+	}
+}
+
+// Scan walks the video directories and updates the index
+func (idx *VideoIndex) Scan() ScanResult {
+	idx.scanMu.Lock()
+	defer idx.scanMu.Unlock()
+
+	start := time.Now()
+	var result ScanResult
+
+	idx.mu.RLock()
+	previous := idx.videos
+	idx.mu.RUnlock()
+
+	videos := make(map[string]*IndexedVideo, len(previous))
+	var pending []videoFile
+	for _, vf := range discoverVideos(idx.cfg) {
+		size := vf.Info.Size()
+		modTime := vf.Info.ModTime()
+
+		// Unchanged files keep their cached entry
+		if existing, ok := previous[vf.Path]; ok {
+			if existing.Size == size && existing.ModTime.Equal(modTime) {
+				videos[vf.Path] = existing
+				continue
+			}
+			result.Updated++
+		} else {
+			result.Added++
+		}
+		pending = append(pending, vf)
+	}
+
+	for _, entry := range idx.indexFiles(pending) {
+		videos[entry.Path] = entry
+	}
+
+	for path := range previous {
+		if _, ok := videos[path]; !ok {
+			result.Removed++
+		}
+	}
+
+	idx.mu.Lock()
+	idx.videos = videos
+	idx.scanned = true
+	idx.lastScan = time.Now()
+	idx.mu.Unlock()
+
+	result.Total = len(videos)
+	result.Changed = result.Added + result.Updated + result.Removed
+
+	// A file that disappeared while another appeared may have been renamed or moved
+	if result.Changed > 0 {
+		if relinked := idx.Reconcile(); relinked > 0 {
+			log.Printf("📇 Relinked stats of %d moved videos", relinked)
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Took = result.Duration.Round(time.Millisecond).String()
+	return result
+}
+
+// indexWorkers bounds how many files are hashed and probed at once during a scan
+const indexWorkers = 8
+
+// indexFiles builds index entries for new or changed files in a bounded worker pool
+func (idx *VideoIndex) indexFiles(files []videoFile) []*IndexedVideo {
+	entries := make([]*IndexedVideo, len(files))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < indexWorkers && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entries[i] = idx.newIndexedVideo(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return entries
+}
+
+// newIndexedVideo builds an index entry, probing the video duration and metadata
+// Duration and metadata are cached in the database by content hash, so they're
+// only probed once per file content
+func (idx *VideoIndex) newIndexedVideo(vf videoFile) *IndexedVideo {
+	entry := &IndexedVideo{
+		Path:     vf.Path,
+		AbsPath:  vf.AbsPath,
+		DirIndex: vf.DirIndex,
+		Name:     vf.Info.Name(),
+		Size:     vf.Info.Size(),
+		ModTime:  vf.Info.ModTime(),
+	}
+
+	// Corrupt files aren't probed further, it would only fail again
+	if reason, corrupt := idx.checkCorrupt(vf); corrupt {
+		entry.Corrupt = reason
+		return entry
+	}
+
+	hash, err := storage.GetFileContentHash(vf.AbsPath, idx.cfg.HashMode)
+	if err != nil {
+		if dur, err := GetVideoDuration(vf.AbsPath); err == nil && dur > 0 {
+			entry.Duration = dur
+		}
+		return entry
+	}
+
+	entry.Hash = hash
+	if dur, ok := idx.store.GetDuration(hash); ok {
+		entry.Duration = dur
+	} else if dur, err := GetVideoDuration(vf.AbsPath); err == nil && dur > 0 {
+		idx.store.SetDuration(hash, dur)
+		entry.Duration = dur
+	}
+
+	entry.Metadata = idx.store.GetMetadata(hash)
+	if entry.Metadata == nil {
+		if m, err := probeMetadata(vf.AbsPath); err == nil {
+			idx.store.SetMetadata(hash, m)
+			entry.Metadata = m
+		}
+	}
+	return entry
+}
+
+// Upsert indexes a single file on disk
+// Returns false if the file doesn't pass the discovery filters
+func (idx *VideoIndex) Upsert(path string) bool {
+	if isInThumbnailDir(idx.cfg, path) {
+		return false
+	}
+	dirIndex, ok := videoDirIndex(idx.cfg, path)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	vf, ok := matchVideoFile(idx.cfg, dirIndex, path, info)
+	if !ok {
+		return false
+	}
+
+	// Hold the scan lock so a concurrent Scan doesn't read the map while we write it
+	idx.scanMu.Lock()
+	defer idx.scanMu.Unlock()
+
+	entry := idx.newIndexedVideo(vf)
+	idx.mu.Lock()
+	idx.videos[vf.Path] = entry
+	idx.mu.Unlock()
+	return true
+}
+
+// Remove drops a file, or every file under a directory, from the index
+// Returns the prefixed paths that were removed
+func (idx *VideoIndex) Remove(path string) []string {
+	idx.scanMu.Lock()
+	defer idx.scanMu.Unlock()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var removed []string
+	dirPrefix := path + string(filepath.Separator)
+	for prefixedPath, v := range idx.videos {
+		if v.AbsPath == path || strings.HasPrefix(v.AbsPath, dirPrefix) {
+			delete(idx.videos, prefixedPath)
+			removed = append(removed, prefixedPath)
+		}
+	}
+	return removed
+}
+
+// Videos returns all indexed videos ordered by path
+// Scans synchronously if the index hasn't been populated yet
+func (idx *VideoIndex) Videos() []*IndexedVideo {
+	idx.mu.RLock()
+	scanned := idx.scanned
+	idx.mu.RUnlock()
+	if !scanned {
+		idx.Scan()
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	videos := make([]*IndexedVideo, 0, len(idx.videos))
+	for _, v := range idx.videos {
+		videos = append(videos, v)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].Path < videos[j].Path
+	})
+	return videos
+}
+
+// Get returns the indexed video for a prefixed path, or nil
+func (idx *VideoIndex) Get(path string) *IndexedVideo {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.videos[path]
+}
+
+// ContentHash returns the current content hash of a video, from the index when
+// it has been hashed there and otherwise read from the file
+func (idx *VideoIndex) ContentHash(videoPath, absVideoPath string) (string, error) {
+	if iv := idx.Get(videoPath); iv != nil && iv.Hash != "" {
+		return iv.Hash, nil
+	}
+	return storage.GetFileContentHash(absVideoPath, idx.cfg.HashMode)
+}
+
+// Duration returns the duration of an indexed video, or 0 if it isn't indexed
+func (idx *VideoIndex) Duration(path string) time.Duration {
+	if iv := idx.Get(path); iv != nil {
+		return iv.Duration
+	}
+	return 0
+}
+
+// LastScan returns the time of the last completed scan
+func (idx *VideoIndex) LastScan() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.lastScan
+}
+
+// Reconcile relinks stats of renamed or moved videos by content hash, then
+// records when newly found videos were first seen
+// Returns the number of stats rows relinked
+func (idx *VideoIndex) Reconcile() int {
+	idx.mu.RLock()
+	current := make(map[string]string, len(idx.videos))
+	seen := make([]storage.SeenVideo, 0, len(idx.videos))
+	for path, v := range idx.videos {
+		current[path] = v.Hash
+		seen = append(seen, storage.SeenVideo{Path: path, Name: v.Name, ModTime: v.ModTime})
+	}
+	idx.mu.RUnlock()
+
+	relinked := idx.store.ReconcileStats(current)
+	idx.store.RecordFirstSeen(seen)
+	return relinked
+}
+
+// ReconcileHandler relinks stats of renamed or moved videos (admin only)
+func ReconcileHandler(cfg *config.Config, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"relinked": index.Reconcile()})
+	}
+}
+
+// RescanHandler forces a refresh of the video index (admin only)
+func RescanHandler(cfg *config.Config, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		result := index.Scan()
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// keyLocks is a set of mutexes created on demand per key
+// Waiting for a lock honours ctx, so a generation timeout or a cancelled
+// request also covers the time spent queued behind another generation.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is one key's mutex, removed once nobody holds or waits for it
+type keyLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: make(map[string]*keyLock)}
+}
+
+// lock blocks until key is free or ctx is done, returning the unlock function
+func (l *keyLocks) lock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	kl := l.locks[key]
+	if kl == nil {
+		kl = &keyLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	select {
+	case kl.ch <- struct{}{}:
+		return func() {
+			<-kl.ch
+			l.release(key, kl)
+		}, nil
+	case <-ctx.Done():
+		l.release(key, kl)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *keyLocks) release(key string, kl *keyLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kl.refs--
+	if kl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// generationLocks lets only one thumbnail or preview generation per content
+// hash run at a time, whether from a batch job or an on-demand request.
+// Keys are "thumbnail:<hash>" and "preview:<hash>".
+var generationLocks = newKeyLocks()
+
+// thumbnailFlights shares an on-demand thumbnail generation between requests
+// for the same content hash, so a grid full of missing thumbnails starts one
+// ffmpeg per video and a failure is reported to all of them at once
+var thumbnailFlights singleflight.Group
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// probeMetadata reads resolution, codecs and bitrate of a video using ffprobe
+func probeMetadata(absPath string) (*storage.MediaMetadata, error) {
+	cmd := ffprobeCommand(context.Background(),
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,width,height:format=bit_rate",
+		"-of", "json",
+		absPath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			BitRate string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+
+	m := &storage.MediaMetadata{}
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Skip cover art and other tiny attached pictures after the first real stream
+			if m.VideoCodec == "" {
+				m.VideoCodec = stream.CodecName
+				m.Width = stream.Width
+				m.Height = stream.Height
+			}
+		case "audio":
+			if m.AudioCodec == "" {
+				m.AudioCodec = stream.CodecName
+			}
+		}
+	}
+	m.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	return m, nil
+}
+
+// resolutionLabel classifies a frame size as 2160p, 1440p, 1080p, 720p, 480p or sd
+// The shorter side is used so portrait videos are classified like landscape ones
+func resolutionLabel(width, height int) string {
+	short := min(width, height)
+	if short == 0 {
+		short = max(width, height)
+	}
+	switch {
+	case short == 0:
+		return ""
+	case short >= 2160:
+		return "2160p"
+	case short >= 1440:
+		return "1440p"
+	case short >= 1080:
+		return "1080p"
+	case short >= 720:
+		return "720p"
+	case short >= 480:
+		return "480p"
+	default:
+		return "sd"
+	}
+}
+
+// normalizeResolution maps user input like "4K" or "1080" to a resolutionLabel value
+func normalizeResolution(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "4k", "uhd":
+		return "2160p"
+	case "2k", "qhd":
+		return "1440p"
+	case "fhd":
+		return "1080p"
+	case "hd":
+		return "720p"
+	}
+	if value != "" && value != "sd" && !strings.HasSuffix(value, "p") {
+		value += "p"
+	}
+	return value
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+var (
+	placeholderOnce sync.Once
+	placeholderJPEG []byte
+)
+
+// placeholderImage returns the JPEG served in place of a thumbnail that couldn't
+// be generated: cfg.PlaceholderThumbnail, or a plain dark grey 16:9 frame
+func placeholderImage(cfg *config.Config) []byte {
+	placeholderOnce.Do(func() {
+		if cfg.PlaceholderThumbnail != "" {
+			data, err := os.ReadFile(cfg.PlaceholderThumbnail)
+			if err == nil {
+				placeholderJPEG = data
+				return
+			}
+			slog.Warn("⚠️  Can't read placeholder thumbnail, using the built-in one", "path", cfg.PlaceholderThumbnail, "error", err)
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 320, 180))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0x2a, 0x2a, 0x2a, 0xff}), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+		placeholderJPEG = buf.Bytes()
+	})
+	return placeholderJPEG
+}
+
+// servePlaceholderThumbnail answers a failed thumbnail request with the
+// placeholder image and a 200, so grids don't show broken images. The
+// X-Thumbnail-Placeholder header marks it, and it isn't cached, so the client
+// can retry later; after a busy error Retry-After says when.
+func servePlaceholderThumbnail(c *gin.Context, cfg *config.Config, err error) {
+	if errors.Is(err, errGenerationBusy) {
+		c.Header("Retry-After", "5")
+	}
+	c.Header("X-Thumbnail-Placeholder", "true")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/jpeg", placeholderImage(cfg))
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -11,7 +12,7 @@ import (
 // PlaylistHandler handles playlist operations
 func PlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		playlists := playlistStore.GetAll()
+		playlists := playlistStore.GetAll(c.GetString("username"))
 		c.JSON(http.StatusOK, gin.H{
 			"playlists": playlists,
 		})
@@ -22,8 +23,9 @@ func PlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage)
 func CreatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
+			Name        string                 `json:"name"`
+			Description string                 `json:"description"`
+			Rules       *storage.PlaylistRules `json:"rules"` // Makes it a smart playlist
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -36,7 +38,17 @@ func CreatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSt
 			return
 		}
 
-		playlist := playlistStore.Create(req.Name, req.Description)
+		if req.Rules == nil {
+			playlist := playlistStore.Create(req.Name, req.Description)
+			c.JSON(http.StatusOK, playlist)
+			return
+		}
+
+		if err := req.Rules.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rules: " + err.Error()})
+			return
+		}
+		playlist := playlistStore.CreateSmart(req.Name, req.Description, req.Rules, c.GetString("username"))
 		c.JSON(http.StatusOK, playlist)
 	}
 }
@@ -45,7 +57,7 @@ func CreatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSt
 func GetPlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		playlist := playlistStore.Get(id)
+		playlist := playlistStore.Get(id, c.GetString("username"))
 		if playlist == nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
 			return
@@ -60,8 +72,10 @@ func UpdatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSt
 		id := c.Param("id")
 
 		var req struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
+			Name        string                 `json:"name"`
+			Description string                 `json:"description"`
+			Rules       *storage.PlaylistRules `json:"rules"`      // Smart playlists only
+			CoverVideo  *string                `json:"coverVideo"` // "" restores the montage cover
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -69,7 +83,24 @@ func UpdatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSt
 			return
 		}
 
-		playlist := playlistStore.Update(id, req.Name, req.Description)
+		if req.Rules != nil {
+			if playlistStore.Type(id) != storage.PlaylistSmart {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only smart playlists have rules"})
+				return
+			}
+			if err := req.Rules.Validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rules: " + err.Error()})
+				return
+			}
+			playlistStore.UpdateRules(id, req.Rules, c.GetString("username"))
+		}
+
+		if req.CoverVideo != nil && !playlistStore.SetCover(id, *req.CoverVideo) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+
+		playlist := playlistStore.Update(id, req.Name, req.Description, c.GetString("username"))
 		if playlist == nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
 			return
@@ -103,7 +134,17 @@ func AddToPlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSto
 			return
 		}
 
-		if !playlistStore.AddVideo(req.PlaylistID, req.VideoPath) {
+		if playlistStore.Type(req.PlaylistID) == storage.PlaylistSmart {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Smart playlists can't be edited by hand"})
+			return
+		}
+
+		if !videoFileExists(cfg, req.VideoPath) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Video not found"})
+			return
+		}
+
+		if _, _, err := playlistStore.AddVideos(req.PlaylistID, []string{req.VideoPath}); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
 			return
 		}
@@ -111,6 +152,77 @@ func AddToPlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistSto
 	}
 }
 
+// BulkPlaylistVideosHandler adds or removes many videos in one request
+func BulkPlaylistVideosHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			Action string   `json:"action"` // add or remove
+			Videos []string `json:"videos"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Videos) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if playlistStore.Type(id) == storage.PlaylistSmart {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Smart playlists can't be edited by hand"})
+			return
+		}
+
+		if req.Action == "add" {
+			var missing []string
+			for _, videoPath := range req.Videos {
+				if !videoFileExists(cfg, videoPath) {
+					missing = append(missing, videoPath)
+				}
+			}
+			if len(missing) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Videos not found", "missing": missing})
+				return
+			}
+		}
+
+		var changed, skipped int
+		var err error
+		switch req.Action {
+		case "add":
+			changed, skipped, err = playlistStore.AddVideos(id, req.Videos)
+		case "remove":
+			changed, skipped, err = playlistStore.RemoveVideos(id, req.Videos)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Action must be add or remove"})
+			return
+		}
+
+		if errors.Is(err, storage.ErrPlaylistNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update playlist"})
+			return
+		}
+
+		videos := []string{}
+		if playlist := playlistStore.Get(id, c.GetString("username")); playlist != nil {
+			videos = playlist.Videos
+		}
+
+		countKey := "added"
+		if req.Action == "remove" {
+			countKey = "removed"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			countKey:  changed,
+			"skipped": skipped,
+			"videos":  videos,
+		})
+	}
+}
+
 // RemoveFromPlaylistHandler removes a video from a playlist
 func RemoveFromPlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -122,6 +234,11 @@ func RemoveFromPlaylistHandler(cfg *config.Config, playlistStore *storage.Playli
 			return
 		}
 
+		if playlistStore.Type(playlistID) == storage.PlaylistSmart {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Smart playlists can't be edited by hand"})
+			return
+		}
+
 		if !playlistStore.RemoveVideo(playlistID, videoPath) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist or video not found"})
 			return
@@ -129,3 +246,27 @@ func RemoveFromPlaylistHandler(cfg *config.Config, playlistStore *storage.Playli
 		c.JSON(http.StatusOK, gin.H{"message": "Video removed from playlist"})
 	}
 }
+
+// ValidatePlaylistHandler lists playlist members whose video files no longer exist
+// Dead entries are only reported, removing them is left to the caller
+func ValidatePlaylistHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		playlist := playlistStore.Get(c.Param("id"), c.GetString("username"))
+		if playlist == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+
+		missing := []string{}
+		for _, videoPath := range playlist.Videos {
+			if !videoFileExists(cfg, videoPath) {
+				missing = append(missing, videoPath)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total":   len(playlist.Videos),
+			"missing": missing,
+		})
+	}
+}
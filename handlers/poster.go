@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers GIF decoding for uploaded posters
+	"image/jpeg"
+	_ "image/png" // Registers PNG decoding for uploaded posters
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// Limits of an uploaded poster
+const (
+	maxPosterBytes     = 10 << 20 // 10MB
+	maxPosterDimension = 8192     // Pixels on either side, checked before decoding
+)
+
+// customThumbnailFile returns the path of an uploaded poster stored for a content hash
+// The name starts with the hash like the generated files, so cleanup keeps it while referenced.
+func customThumbnailFile(cfg *config.Config, hash string) string {
+	return cacheFile(cfg, hash+".custom.jpg")
+}
+
+// customThumbnail returns the uploaded poster of a video, if it has one on disk
+func customThumbnail(cfg *config.Config, store *storage.Storage, videoPath string) (string, bool) {
+	hash := store.GetCustomThumbnail(videoPath)
+	if hash == "" {
+		return "", false
+	}
+	path := customThumbnailFile(cfg, hash)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// decodePoster checks that data is a JPEG, PNG or GIF of a sane size and decodes it
+func decodePoster(data []byte) (image.Image, error) {
+	header, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("not a JPEG, PNG or GIF image")
+	}
+	if header.Width > maxPosterDimension || header.Height > maxPosterDimension {
+		return nil, fmt.Errorf("image is larger than %dx%d", maxPosterDimension, maxPosterDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("image is corrupt")
+	}
+	return img, nil
+}
+
+// UploadThumbnailHandler replaces the thumbnail of a video with an uploaded
+// image, sent as the "image" field of a multipart form (admin only)
+// The image is re-encoded as JPEG and stored by the video's content hash.
+// GetThumbnail serves it in every size instead of the generated frame, and
+// regeneration leaves it alone until it's removed with DeleteThumbnailHandler.
+func UploadThumbnailHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		videoPath := c.Query("video")
+		if !videoFileExists(cfg, videoPath) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPosterBytes+1<<20) // Room for the multipart framing
+		header, err := c.FormFile("image")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No image uploaded"})
+			return
+		}
+		if header.Size > maxPosterBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+			return
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+			return
+		}
+
+		img, err := decodePoster(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image: " + err.Error()})
+			return
+		}
+
+		absVideoPath, _ := parseVideoPath(videoPath, cfg)
+		hash, err := storage.GetFileContentHash(absVideoPath, cfg.HashMode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
+			return
+		}
+
+		if err := writePoster(customThumbnailFile(cfg, hash), img); err != nil {
+			requestLog(c).Error("❌ Failed to save poster", "path", videoPath, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+			return
+		}
+		store.SetCustomThumbnail(videoPath, filepath.Base(absVideoPath), hash)
+
+		requestLog(c).Info("🖼️  Uploaded custom poster", "path", videoPath)
+		c.JSON(http.StatusOK, gin.H{"message": "Poster uploaded", "hash": hash})
+	}
+}
+
+// DeleteThumbnailHandler removes the uploaded poster of a video, so the
+// generated thumbnail is served again (admin only)
+// The file is left for cleanup, another copy of the same content may still use it.
+func DeleteThumbnailHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		videoPath := c.Query("video")
+		if videoPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No video specified"})
+			return
+		}
+
+		if store.GetCustomThumbnail(videoPath) == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No custom poster"})
+			return
+		}
+		store.SetCustomThumbnail(videoPath, "", "")
+
+		c.JSON(http.StatusOK, gin.H{"message": "Poster removed"})
+	}
+}
+
+// writePoster encodes img as JPEG to path, via a temp file so a concurrent
+// request never serves a partial image
+func writePoster(path string, img image.Image) error {
+	if err := ensureCacheDir(path); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(file, img, &jpeg.Options{Quality: 90})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
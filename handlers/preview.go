@@ -1,11 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -21,15 +21,14 @@ type PreviewGenerator struct {
 	cfg      *config.Config
 	storage  *storage.Storage
 	workers  int
+	threads  int
 	callback ProgressCallback
 }
 
 // NewPreviewGenerator creates a preview generator
 func NewPreviewGenerator(cfg *config.Config, storage *storage.Storage, workers int) *PreviewGenerator {
-	if workers < 1 {
-		workers = 4
-	}
-	return &PreviewGenerator{cfg: cfg, storage: storage, workers: workers}
+	workers = clampWorkers(workers, 4)
+	return &PreviewGenerator{cfg: cfg, storage: storage, workers: workers, threads: ffmpegThreads(cfg, workers)}
 }
 
 // SetProgressCallback sets the progress callback
@@ -38,39 +37,28 @@ func (pg *PreviewGenerator) SetProgressCallback(cb ProgressCallback) {
 }
 
 // GenerateAll generates previews for all videos concurrently
-func (pg *PreviewGenerator) GenerateAll() error {
+// When ctx is cancelled, jobs already running finish but no new ones start
+func (pg *PreviewGenerator) GenerateAll(ctx context.Context) error {
 	if err := os.MkdirAll(pg.cfg.ThumbnailDir, 0755); err != nil {
 		return err
 	}
 
-	// Find all video files from all directories
+	// Find all video files from all directories, leaving out quarantined ones
+	files := discoverVideos(pg.cfg)
+	skip := quarantined(pg.storage, files)
 	var videos []string
-	for dirIndex, videoDir := range pg.cfg.VideoDirs {
-		filepath.WalkDir(videoDir, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".mp4") {
-				if strings.HasPrefix(d.Name(), "._") {
-					return nil
-				}
-				info, err := d.Info()
-				if err != nil {
-					return nil
-				}
-				if info.Size() < 10*1024*1024 {
-					return nil
-				}
-				relPath, _ := filepath.Rel(videoDir, path)
-				// Prefix with directory index
-				prefixedPath := fmt.Sprintf("%d:%s", dirIndex, relPath)
-				videos = append(videos, prefixedPath)
-			}
-			return nil
-		})
+	for _, vf := range files {
+		if !skip[vf.Path] {
+			videos = append(videos, vf.Path)
+		}
+	}
+	if len(skip) > 0 {
+		slog.Info("⏭️  Skipping corrupt videos", "count", len(skip))
 	}
 
-	log.Printf("🎬 Found %d videos, generating previews with %d workers...", len(videos), pg.workers)
+	slog.Info("🎬 Generating previews", "videos", len(videos), "workers", pg.workers)
+
+	opts := defaultPreviewOptions(pg.cfg)
 
 	jobs := make(chan string, len(videos))
 	results := make(chan struct {
@@ -85,7 +73,16 @@ func (pg *PreviewGenerator) GenerateAll() error {
 		go func(workerID int) {
 			defer wg.Done()
 			for videoPath := range jobs {
-				err := pg.generatePreview(videoPath)
+				if !waitForMemory(ctx, pg.cfg) {
+					return
+				}
+				err := generateBatch(ctx, pg.cfg, videoPath, func(ctx context.Context) error {
+					return pg.generatePreview(ctx, videoPath, "", opts)
+				})
+				if ctx.Err() != nil {
+					// Interrupted jobs are reported as skipped, not failed
+					return
+				}
 				results <- struct {
 					path string
 					err  error
@@ -112,25 +109,75 @@ func (pg *PreviewGenerator) GenerateAll() error {
 
 	for result := range results {
 		if result.err != nil {
-			log.Printf("❌ Failed: %s - %v", result.path, result.err)
+			slog.Error("❌ Failed to generate preview", "path", result.path, "error", result.err)
 			failed++
 		} else {
 			success++
 			if success%10 == 0 {
-				log.Printf("✅ Progress: %d/%d previews generated", success, total)
+				slog.Info("✅ Progress", "kind", "preview", "done", success, "total", total)
 			}
 		}
 		if pg.callback != nil {
-			pg.callback(total, success, failed)
+			pg.callback(total, success, failed, result.path)
 		}
 	}
 
-	log.Printf("🎬 Preview generation complete: %d success, %d failed", success, failed)
+	if err := ctx.Err(); err != nil {
+		slog.Info("🎬 Preview generation stopped", "success", success, "failed", failed, "skipped", total-success-failed)
+		return err
+	}
+
+	slog.Info("🎬 Preview generation complete", "success", success, "failed", failed)
 	return nil
 }
 
-// generatePreview generates a preview for a single video (60 segments, 0.5 second each = 30 seconds total)
-func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
+// previewOptions are the encoding parameters of an MP4 preview
+type previewOptions struct {
+	Segments        int     // Number of segments sampled across the video
+	SegmentDuration float64 // Length of each segment in seconds
+	CRF             int     // x264 constant rate factor
+	Preset          string  // x264 preset
+}
+
+// defaultPreviewOptions returns the preview options from config
+func defaultPreviewOptions(cfg *config.Config) previewOptions {
+	return previewOptions{
+		Segments:        cfg.PreviewSegments,
+		SegmentDuration: cfg.PreviewSegmentDuration,
+		CRF:             cfg.PreviewCRF,
+		Preset:          cfg.PreviewPreset,
+	}
+}
+
+// previewOptionsForQuality adjusts the configured options for a one-off quality request
+// Supports "low" and "high", anything else returns the configured options
+func previewOptionsForQuality(cfg *config.Config, quality string) previewOptions {
+	opts := defaultPreviewOptions(cfg)
+	switch quality {
+	case "high":
+		opts.CRF = 20
+		opts.Preset = "medium"
+	case "low":
+		opts.CRF = 34
+		opts.Preset = "veryfast"
+	}
+	return opts
+}
+
+// cacheSuffix identifies non-default options in the preview filename, so changing
+// them triggers regeneration. The original defaults map to no suffix to keep
+// previews generated before these options existed valid
+func (o previewOptions) cacheSuffix() string {
+	if o.Segments == 60 && o.SegmentDuration == 0.5 && o.CRF == 28 && o.Preset == "fast" {
+		return ""
+	}
+	return fmt.Sprintf("_s%d_d%g_q%d_%s", o.Segments, o.SegmentDuration, o.CRF, o.Preset)
+}
+
+// generatePreview generates a preview for a single video (by default 60 segments, 0.5 second each = 30 seconds total)
+// contentHash is the video's content hash when the caller already computed it,
+// empty to compute it here
+func (pg *PreviewGenerator) generatePreview(ctx context.Context, prefixedPath, contentHash string, opts previewOptions) error {
 	// Parse prefixed path
 	absVideoPath, err := parseVideoPath(prefixedPath, pg.cfg)
 	if err != nil {
@@ -140,23 +187,31 @@ func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
 	// Get video name from path
 	videoName := filepath.Base(absVideoPath)
 
-	// Check database for existing hash
+	// Check database for existing hash, unless the caller knows the content changed
 	existingHash := pg.storage.GetPreviewHash(prefixedPath)
-	if existingHash != "" {
-		previewPath := filepath.Join(pg.cfg.ThumbnailDir, existingHash+".mp4")
+	if existingHash != "" && (contentHash == "" || existingHash == contentHash) {
+		previewPath := previewFile(pg.cfg, existingHash, opts)
 		if _, err := os.Stat(previewPath); err == nil {
 			return nil // Already exists with valid hash
 		}
 	}
 
 	// Calculate file content hash
-	contentHash, err := storage.GetFileContentHash(absVideoPath)
-	if err != nil {
-		return fmt.Errorf("failed to calculate content hash: %w", err)
+	if contentHash == "" {
+		contentHash, err = storage.GetFileContentHash(absVideoPath, pg.cfg.HashMode)
+		if err != nil {
+			return fmt.Errorf("failed to calculate content hash: %w", err)
+		}
 	}
 
-	previewFilename := contentHash + ".mp4"
-	previewPath := filepath.Join(pg.cfg.ThumbnailDir, previewFilename)
+	previewPath := previewFile(pg.cfg, contentHash, opts)
+
+	// One generation per content hash at a time; whoever waited finds the file below
+	unlock, err := generationLocks.lock(ctx, "preview:"+contentHash)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Check if preview already exists (same content)
 	if _, err := os.Stat(previewPath); err == nil {
@@ -166,13 +221,13 @@ func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
 	}
 
 	// Get video duration
-	durationCmd := exec.Command("ffprobe",
+	durationCmd := ffprobeCommand(ctx,
 		"-v", "error",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
 		absVideoPath,
 	)
-	durationOutput, err := durationCmd.Output()
+	durationOutput, err := outputFFmpeg(durationCmd)
 	if err != nil {
 		return fmt.Errorf("failed to get duration: %w", err)
 	}
@@ -183,15 +238,50 @@ func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
 		duration = 600
 	}
 
-	// Generate segments, 0.5 second each, evenly distributed
-	// Timestamps: ~2%, 3.6%, 5.2%, ..., 98% of duration (every ~1.6%)
-	segments := pg.cfg.PreviewSegments
-	const segmentDuration = 0.5
-	
-	tempDir := filepath.Join(pg.cfg.ThumbnailDir, "temp_"+contentHash[:8])
-	os.MkdirAll(tempDir, 0755)
+	if err := ensureCacheDir(previewPath); err != nil {
+		return fmt.Errorf("failed to create preview dir: %w", err)
+	}
+	// The random suffix keeps concurrent generations of the same content apart
+	tempDir, err := os.MkdirTemp(pg.cfg.ThumbnailDir, "temp_"+contentHash[:8]+"_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
 	defer os.RemoveAll(tempDir)
 
+	// Animated image previews sample single frames instead of video segments
+	if pg.cfg.PreviewFormat == "webp" || pg.cfg.PreviewFormat == "gif" {
+		if err := generateAnimatedPreview(ctx, absVideoPath, duration, tempDir, previewPath, pg.cfg.PreviewFormat); err != nil {
+			os.Remove(previewPath)
+			return err
+		}
+		pg.storage.SetPreviewHash(prefixedPath, videoName, contentHash)
+		return nil
+	}
+
+	encoder := previewEncoder(pg.cfg, pg.threads)
+	err = encodePreview(ctx, absVideoPath, duration, tempDir, previewPath, opts, encoder)
+	if err != nil && encoder.hardware() && ctx.Err() == nil {
+		// Not every file decodes or encodes on the GPU, redo it in software
+		slog.Warn("⚠️  Hardware encoding failed, retrying in software", "path", prefixedPath, "hwaccel", encoder.hwaccel, "error", err)
+		err = encodePreview(ctx, absVideoPath, duration, tempDir, previewPath, opts, softwareEncoder(pg.threads))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update database with new hash
+	pg.storage.SetPreviewHash(prefixedPath, videoName, contentHash)
+	return nil
+}
+
+// encodePreview concatenates short segments sampled across the video into an
+// MP4 preview, falling back to 30 seconds from the middle
+func encodePreview(ctx context.Context, absVideoPath string, duration float64, tempDir, previewPath string, opts previewOptions, encoder videoEncoder) error {
+	// Generate segments (0.5 second each by default), evenly distributed
+	// Timestamps: ~2%, 3.6%, 5.2%, ..., 98% of duration (every ~1.6%)
+	segments := opts.Segments
+	segmentDuration := opts.SegmentDuration
+
 	segmentFiles := make([]string, segments)
 	success := true
 
@@ -201,19 +291,12 @@ func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
 		segmentPath := filepath.Join(tempDir, fmt.Sprintf("seg%d.ts", i))
 		segmentFiles[i] = segmentPath
 
-		cmd := exec.Command("ffmpeg",
-			"-y",
-			"-ss", fmt.Sprintf("%.2f", ts),
-			"-i", absVideoPath,
-			"-t", fmt.Sprintf("%.1f", segmentDuration),
-			"-c:v", "libx264",
-			"-crf", "28",
-			"-preset", "fast",
-			"-an",
-			"-f", "mpegts",
-			segmentPath,
-		)
-		if err := cmd.Run(); err != nil {
+		args := append([]string{"-y", "-ss", fmt.Sprintf("%.2f", ts)}, encoder.inputArgs()...)
+		args = append(args, "-i", absVideoPath, "-t", fmt.Sprintf("%.2f", segmentDuration))
+		args = append(args, encoder.outputArgs(opts.CRF, opts.Preset)...)
+		args = append(args, "-an", "-f", "mpegts", segmentPath)
+		cmd := ffmpegCommand(ctx, args...)
+		if err := runFFmpeg(cmd); err != nil {
 			success = false
 			break
 		}
@@ -221,44 +304,89 @@ func (pg *PreviewGenerator) generatePreview(prefixedPath string) error {
 
 	if success {
 		concatList := "concat:" + strings.Join(segmentFiles, "|")
-		concatCmd := exec.Command("ffmpeg",
+		concatCmd := ffmpegCommand(ctx,
 			"-y",
 			"-i", concatList,
 			"-c", "copy",
 			"-movflags", "+faststart",
 			previewPath,
 		)
-		if err := concatCmd.Run(); err != nil {
+		if err := runFFmpeg(concatCmd); err != nil {
 			success = false
 		}
 	}
 
 	if !success {
+		// The segments may have used up the time limit, don't start another encode
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Fallback: simple 30-second preview from middle
 		midPoint := duration / 2
 		if midPoint < 15 {
 			midPoint = 0
 		}
-		fallbackCmd := exec.Command("ffmpeg",
+		args := append([]string{"-y", "-ss", fmt.Sprintf("%.2f", midPoint)}, encoder.inputArgs()...)
+		args = append(args, "-i", absVideoPath, "-t", "30")
+		args = append(args, encoder.outputArgs(opts.CRF, opts.Preset)...)
+		args = append(args, "-an", "-movflags", "+faststart", previewPath)
+		fallbackCmd := ffmpegCommand(ctx, args...)
+		if err := runFFmpeg(fallbackCmd); err != nil {
+			os.Remove(previewPath) // Don't leave a partial file that looks cached
+			return err
+		}
+	}
+	return nil
+}
+
+// animatedPreviewFrames is the number of frames sampled for webp/gif previews
+const animatedPreviewFrames = 12
+
+// generateAnimatedPreview encodes a small looping WebP or GIF from frames
+// sampled evenly across the video
+func generateAnimatedPreview(ctx context.Context, videoPath string, duration float64, tempDir, outputPath, format string) error {
+	for i := 0; i < animatedPreviewFrames; i++ {
+		// Same 2%-98% spread as the MP4 segments
+		ts := duration * (2 + float64(i)*96/animatedPreviewFrames) / 100.0
+		framePath := filepath.Join(tempDir, fmt.Sprintf("frame%02d.jpg", i))
+
+		cmd := ffmpegCommand(ctx,
 			"-y",
-			"-ss", fmt.Sprintf("%.2f", midPoint),
-			"-i", absVideoPath,
-			"-t", "30",
-			"-c:v", "libx264",
-			"-crf", "28",
-			"-preset", "fast",
-			"-an",
-			"-movflags", "+faststart",
-			previewPath,
+			"-ss", fmt.Sprintf("%.2f", ts),
+			"-i", videoPath,
+			"-vframes", "1",
+			"-vf", "scale=320:-2",
+			"-q:v", "3",
+			framePath,
 		)
-		if err := fallbackCmd.Run(); err != nil {
-			return err
+		if err := runFFmpeg(cmd); err != nil {
+			return fmt.Errorf("failed to extract frame %d: %w", i, err)
 		}
 	}
 
-	// Update database with new hash
-	pg.storage.SetPreviewHash(prefixedPath, videoName, contentHash)
-	return nil
+	args := []string{"-y", "-framerate", "2", "-i", filepath.Join(tempDir, "frame%02d.jpg"), "-loop", "0"}
+	if format == "webp" {
+		args = append(args, "-c:v", "libwebp", "-quality", "70")
+	} else {
+		// Build a palette from the frames for better GIF colors
+		args = append(args, "-vf", "split[a][b];[a]palettegen[p];[b][p]paletteuse")
+	}
+	args = append(args, outputPath)
+
+	return runFFmpeg(ffmpegCommand(ctx, args...))
+}
+
+// previewFile returns the path of the preview for a content hash in the configured format
+// Animated previews use a .preview suffix so they don't collide with WebP thumbnails
+func previewFile(cfg *config.Config, hash string, opts previewOptions) string {
+	switch cfg.PreviewFormat {
+	case "webp":
+		return cacheFile(cfg, hash+".preview.webp")
+	case "gif":
+		return cacheFile(cfg, hash+".preview.gif")
+	default:
+		return cacheFile(cfg, hash+opts.cacheSuffix()+".mp4")
+	}
 }
 
 // GetPreview returns or generates a preview video
@@ -303,41 +431,53 @@ func GetPreview(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
 			return
 		}
 
+		// Optional one-off quality override (low, high)
+		opts := previewOptionsForQuality(cfg, c.Query("quality"))
+
 		// Check database for existing hash
 		existingHash := store.GetPreviewHash(videoPath)
 		if existingHash != "" {
-			previewPath := filepath.Join(cfg.ThumbnailDir, existingHash+".mp4")
+			previewPath := previewFile(cfg, existingHash, opts)
 			if _, err := os.Stat(previewPath); err == nil {
-				c.File(previewPath)
+				serveCacheFile(c, previewPath)
 				return
 			}
 		}
 
 		// Calculate file content hash
-		contentHash, err := storage.GetFileContentHash(absVideoPath)
+		contentHash, err := storage.GetFileContentHash(absVideoPath, cfg.HashMode)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
 			return
 		}
 
-		previewFilename := contentHash + ".mp4"
-		previewPath := filepath.Join(cfg.ThumbnailDir, previewFilename)
+		previewPath := previewFile(cfg, contentHash, opts)
 
 		// Check if preview exists (same content already generated)
 		if _, err := os.Stat(previewPath); err == nil {
 			// Update database and return
 			store.SetPreviewHash(videoPath, filepath.Base(absVideoPath), contentHash)
-			c.File(previewPath)
+			serveCacheFile(c, previewPath)
+			return
+		}
+
+		// Previews already on disk are still served, but nothing new is generated when disabled
+		if !cfg.PreviewsEnabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Previews are disabled"})
 			return
 		}
 
 		// Generate preview on-demand (fallback)
 		pg := NewPreviewGenerator(cfg, store, 1)
-		if err := pg.generatePreview(videoPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate preview"})
+		err = generateOnDemand(context.Background(), cfg, videoPath, func(ctx context.Context) error {
+			return pg.generatePreview(ctx, videoPath, contentHash, opts)
+		})
+		if err != nil {
+			requestLog(c).Error("❌ Failed to generate preview", "path", videoPath, "error", err)
+			generationFailed(c, cfg, "Failed to generate preview", err)
 			return
 		}
 
-		c.File(previewPath)
+		serveCacheFile(c, previewPath)
 	}
 }
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestGeneratePreviewUsesGivenHash(t *testing.T) {
+	cfg, store := newGeneratorTest(t, "preview.mp4")
+	pg := NewPreviewGenerator(cfg, store, 1)
+	opts := defaultPreviewOptions(cfg)
+
+	if err := pg.generatePreview(context.Background(), "0:preview.mp4", precomputedHash, opts); err != nil {
+		t.Fatalf("generatePreview: %v", err)
+	}
+
+	if _, err := os.Stat(previewFile(cfg, precomputedHash, opts)); err != nil {
+		t.Errorf("preview not written under the given hash: %v", err)
+	}
+	if got := store.GetPreviewHash("0:preview.mp4"); got != precomputedHash {
+		t.Errorf("stored preview hash = %q, want %q", got, precomputedHash)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// lastRandom remembers the video each user was last served by RandomVideoHandler
+var lastRandom sync.Map // username -> prefixed path
+
+// RandomVideoHandler returns one random video matching the list filters
+// (dir, tags, tagMode, durationMin, durationMax, viewsMin, viewsMax, likesMin,
+// likesMax, resolution, bookmarked, liked).
+// With weight=hotness popular videos are picked proportionally more often.
+// The video served last to the same user is skipped unless it's the only match.
+func RandomVideoHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := parseVideoFilter(c, cfg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown video directory"})
+			return
+		}
+		byHotness := c.Query("weight") == "hotness"
+
+		username := c.GetString("username")
+		allStats := store.GetAllStats(username)
+		allTags := store.GetAllVideoTags()
+		last, _ := lastRandom.Load(username)
+
+		// Weighted reservoir sampling (Efraimidis-Spirakis) in one pass over the
+		// index: the video with the largest u^(1/weight) wins, so only the current
+		// pick is kept instead of collecting every match
+		var picked, repeat *Video
+		bestKey := -1.0
+		for _, iv := range index.Videos() {
+			if !filter.matchIndexed(iv) {
+				continue
+			}
+			video := newVideo(cfg, iv, videoStats(allStats, iv.Path), videoTags(allTags, iv.Path))
+			if !filter.match(&video) {
+				continue
+			}
+			if iv.Path == last {
+				repeat = &video
+				continue
+			}
+
+			weight := 1.0
+			if byHotness {
+				weight += math.Max(video.Hotness, 0)
+			}
+			if key := math.Pow(rand.Float64(), 1/weight); key > bestKey {
+				bestKey = key
+				picked = &video
+			}
+		}
+
+		if picked == nil {
+			picked = repeat
+		}
+		if picked == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No videos match"})
+			return
+		}
+
+		lastRandom.Store(username, picked.Path)
+		result := []Video{*picked}
+		setSubtitles(index, result)
+		c.JSON(http.StatusOK, result[0])
+	}
+}
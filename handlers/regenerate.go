@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// removeThumbnailFiles deletes the full-size thumbnail of a hash and all its variants
+func removeThumbnailFiles(cfg *config.Config, hash string) {
+	sizes := append([]config.ThumbnailSize{{Name: ""}}, cfg.ThumbnailSizes...)
+	for _, size := range sizes {
+		for _, format := range []string{"jpg", "webp"} {
+			os.Remove(thumbnailFile(cfg, hash, size.Name, format))
+		}
+	}
+}
+
+// removePreviewFiles deletes the preview of a hash in the configured and one-off qualities
+func removePreviewFiles(cfg *config.Config, hash string) {
+	for _, quality := range []string{"", "low", "high"} {
+		os.Remove(previewFile(cfg, hash, previewOptionsForQuality(cfg, quality)))
+	}
+}
+
+// cachedHashes returns the recorded hash and the video's current content hash,
+// either of which may name the cached files
+func cachedHashes(cfg *config.Config, videoPath, recorded string) []string {
+	hashes := []string{}
+	if recorded != "" {
+		hashes = append(hashes, recorded)
+	}
+	if absPath, err := parseVideoPath(videoPath, cfg); err == nil {
+		if hash, err := storage.GetFileContentHash(absPath, cfg.HashMode); err == nil && hash != recorded {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// regenerateRequest identifies the video to regenerate
+// Position optionally picks another thumbnail frame, in the format of THUMBNAIL_POSITION
+type regenerateRequest struct {
+	Path     string `json:"path"`
+	Position string `json:"position"`
+}
+
+// bindRegenerateRequest reads the request, responding with an error if it doesn't name a video
+func bindRegenerateRequest(c *gin.Context, cfg *config.Config) (regenerateRequest, bool) {
+	var req regenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return req, false
+	}
+	if !videoFileExists(cfg, req.Path) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return req, false
+	}
+	return req, true
+}
+
+// RegenerateThumbnailHandler deletes a video's cached thumbnail and creates it again (admin only)
+// Without a position the same frame comes back, so rerolling a black frame
+// needs one, e.g. "smart" or "25%"
+func RegenerateThumbnailHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		req, ok := bindRegenerateRequest(c, cfg)
+		if !ok {
+			return
+		}
+		if req.Position != "" && !validThumbnailPosition(req.Position) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid position"})
+			return
+		}
+		videoPath := req.Path
+
+		for _, hash := range cachedHashes(cfg, videoPath, store.GetThumbnailHash(videoPath)) {
+			removeThumbnailFiles(cfg, hash)
+		}
+		store.SetThumbnailHash(videoPath, "", "")
+
+		tg := NewThumbnailGenerator(cfg, store, 1)
+		err := generateOnDemand(c.Request.Context(), cfg, videoPath, func(ctx context.Context) error {
+			return tg.generateThumbnailAt(ctx, videoPath, "", req.Position)
+		})
+		if err != nil {
+			requestLog(c).Error("❌ Failed to regenerate thumbnail", "path", videoPath, "error", err)
+			generationFailed(c, cfg, "Failed to regenerate thumbnail", err)
+			return
+		}
+
+		requestLog(c).Info("🖼️  Regenerated thumbnail", "path", videoPath)
+		c.JSON(http.StatusOK, gin.H{"message": "Thumbnail regenerated", "hash": store.GetThumbnailHash(videoPath)})
+	}
+}
+
+// RegeneratePreviewHandler deletes a video's cached previews and creates the preview again (admin only)
+func RegeneratePreviewHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		if !cfg.PreviewsEnabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Preview generation is disabled"})
+			return
+		}
+
+		req, ok := bindRegenerateRequest(c, cfg)
+		if !ok {
+			return
+		}
+		videoPath := req.Path
+
+		for _, hash := range cachedHashes(cfg, videoPath, store.GetPreviewHash(videoPath)) {
+			removePreviewFiles(cfg, hash)
+		}
+		store.SetPreviewHash(videoPath, "", "")
+
+		pg := NewPreviewGenerator(cfg, store, 1)
+		err := generateOnDemand(c.Request.Context(), cfg, videoPath, func(ctx context.Context) error {
+			return pg.generatePreview(ctx, videoPath, "", defaultPreviewOptions(cfg))
+		})
+		if err != nil {
+			requestLog(c).Error("❌ Failed to regenerate preview", "path", videoPath, "error", err)
+			generationFailed(c, cfg, "Failed to regenerate preview", err)
+			return
+		}
+
+		requestLog(c).Info("🎬 Regenerated preview", "path", videoPath)
+		c.JSON(http.StatusOK, gin.H{"message": "Preview regenerated", "hash": store.GetPreviewHash(videoPath)})
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"cmp"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// relatedCount is how many videos RelatedVideosHandler returns
+const relatedCount = 12
+
+// Scores of the signals that relate two videos; a candidate needs at least one
+const (
+	relatedSeriesScore   = 4 // Same series, i.e. the same name apart from the episode number
+	relatedDirScore      = 2 // Same directory
+	relatedTagScore      = 1 // Per shared tag
+	relatedDurationScore = 1 // Duration within relatedDurationRatio of the current video
+)
+
+// relatedDurationRatio is how far apart durations still count as similar
+const relatedDurationRatio = 0.25
+
+// RelatedVideosHandler suggests what to watch after a video: other videos of
+// the same series or directory, of similar duration or sharing tags, ranked
+// by how many of those they match and then by hotness. Videos the user has
+// already watched to the end are left out.
+func RelatedVideosHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current := index.Get(c.Query("video"))
+		if current == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+
+		allStats := store.GetAllStats(c.GetString("username"))
+		allTags := store.GetAllVideoTags()
+		currentTags := allTags[current.Path]
+		currentSeries := seriesName(current.Name)
+
+		type candidate struct {
+			video Video
+			score int
+		}
+		var candidates []candidate
+		for _, iv := range index.Videos() {
+			if iv.Path == current.Path || iv.Corrupt != "" {
+				continue
+			}
+			stats := videoStats(allStats, iv.Path)
+			if stats.Completed {
+				continue
+			}
+
+			score := 0
+			if currentSeries != "" && seriesName(iv.Name) == currentSeries {
+				score += relatedSeriesScore
+			}
+			if sameDirectory(current, iv) {
+				score += relatedDirScore
+			}
+			tags := videoTags(allTags, iv.Path)
+			for _, tag := range tags {
+				if slices.Contains(currentTags, tag) {
+					score += relatedTagScore
+				}
+			}
+			if similarDuration(current, iv) {
+				score += relatedDurationScore
+			}
+			if score == 0 {
+				continue
+			}
+			candidates = append(candidates, candidate{video: newVideo(cfg, iv, stats, tags), score: score})
+		}
+
+		slices.SortFunc(candidates, func(a, b candidate) int {
+			if c := cmp.Compare(b.score, a.score); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(b.video.Hotness, a.video.Hotness); c != 0 {
+				return c
+			}
+			return naturalCompare(a.video.Name, b.video.Name)
+		})
+
+		videos := make([]Video, 0, relatedCount)
+		for _, cand := range candidates[:min(len(candidates), relatedCount)] {
+			videos = append(videos, cand.video)
+		}
+		setSubtitles(index, videos)
+
+		c.JSON(http.StatusOK, gin.H{"video": current.Path, "videos": videos})
+	}
+}
+
+// seriesName returns the part of a file name identifying its series: the
+// letters of the name without extension, lowercased, so "Show S01E02.mkv" and
+// "show.s01e03.mp4" match. Empty for names with no digits, which aren't treated
+// as part of a series.
+func seriesName(name string) string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	if !strings.ContainsFunc(base, unicode.IsDigit) {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sameDirectory reports whether two videos are in the same directory of the same
+// VIDEO_DIR; comparing the prefixed paths alone would give "." for the top
+// level of every VIDEO_DIR
+func sameDirectory(a, b *IndexedVideo) bool {
+	return a.DirIndex == b.DirIndex && relativeDir(a) == relativeDir(b)
+}
+
+// relativeDir returns the directory of a video relative to its VIDEO_DIR
+func relativeDir(iv *IndexedVideo) string {
+	rel := filepath.ToSlash(strings.SplitN(iv.Path, ":", 2)[1])
+	return path.Dir(rel)
+}
+
+// similarDuration reports whether two videos with known durations are within
+// relatedDurationRatio of each other
+func similarDuration(a, b *IndexedVideo) bool {
+	if a.Duration <= 0 || b.Duration <= 0 {
+		return false
+	}
+	diff := float64(a.Duration - b.Duration)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= float64(a.Duration)*relatedDurationRatio
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import "testing"
+
+func TestSameDirectory(t *testing.T) {
+	tests := []struct {
+		a, b IndexedVideo
+		want bool
+	}{
+		{IndexedVideo{Path: "0:a.mp4"}, IndexedVideo{Path: "0:b.mp4"}, true},
+		{IndexedVideo{Path: "0:a.mp4"}, IndexedVideo{Path: "1:b.mp4", DirIndex: 1}, false},
+		{IndexedVideo{Path: "0:show/a.mp4"}, IndexedVideo{Path: "0:show/b.mp4"}, true},
+		{IndexedVideo{Path: "0:show/a.mp4"}, IndexedVideo{Path: "1:show/b.mp4", DirIndex: 1}, false},
+		{IndexedVideo{Path: "0:show/a.mp4"}, IndexedVideo{Path: "0:b.mp4"}, false},
+		{IndexedVideo{Path: "0:show/a.mp4"}, IndexedVideo{Path: "0:show/extras/b.mp4"}, false},
+	}
+	for _, tt := range tests {
+		if got := sameDirectory(&tt.a, &tt.b); got != tt.want {
+			t.Errorf("sameDirectory(%s, %s) = %v, want %v", tt.a.Path, tt.b.Path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// videoFile represents a video discovered in one of the video directories
+type videoFile struct {
+	Path     string      // Prefixed path (dirIndex:relPath)
+	AbsPath  string      // Path on disk
+	DirIndex int         // Index into cfg.VideoDirs
+	Info     fs.FileInfo // File info from the walk
+}
+
+// discoverVideos walks all video directories and returns the video files found
+// Applies the extension, AppleDouble and minimum size filters, and never descends
+// into the thumbnail directory so generated previews aren't picked up as videos
+func discoverVideos(cfg *config.Config) []videoFile {
+	var videos []videoFile
+
+	for dirIndex, videoDir := range cfg.VideoDirs {
+		filepath.WalkDir(videoDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				// Previews are written next to thumbnails, so this covers both
+				if isInThumbnailDir(cfg, path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !isVideoFile(d.Name(), cfg) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			if vf, ok := matchVideoFile(cfg, dirIndex, path, info); ok {
+				videos = append(videos, vf)
+			}
+			return nil
+		})
+	}
+
+	return videos
+}
+
+// matchVideoFile applies the discovery filters to a single file found under
+// cfg.VideoDirs[dirIndex] and builds its prefixed path
+func matchVideoFile(cfg *config.Config, dirIndex int, path string, info fs.FileInfo) (videoFile, bool) {
+	if info.IsDir() || !isVideoFile(info.Name(), cfg) {
+		return videoFile{}, false
+	}
+
+	// Skip macOS AppleDouble files (._filename)
+	if strings.HasPrefix(info.Name(), "._") {
+		return videoFile{}, false
+	}
+
+	// Skip very small files (likely corrupted or placeholder)
+	if info.Size() < cfg.MinFileSize {
+		return videoFile{}, false
+	}
+
+	relPath, err := filepath.Rel(cfg.VideoDirs[dirIndex], path)
+	if err != nil {
+		return videoFile{}, false
+	}
+
+	// Prefix path with directory index to distinguish sources
+	// Format: dirIndex:relPath (e.g., "0:video.mp4", "1:subdir/video.mp4")
+	prefixedPath := fmt.Sprintf("%d:%s", dirIndex, relPath)
+
+	// The prefixed path is the key for stats and cache lookups, so skip
+	// paths that couldn't round-trip through the API unchanged
+	if err := validateVideoPath(prefixedPath, cfg); err != nil {
+		log.Printf("⚠️  Skipping %s: %v", path, err)
+		return videoFile{}, false
+	}
+
+	return videoFile{
+		Path:     prefixedPath,
+		AbsPath:  path,
+		DirIndex: dirIndex,
+		Info:     info,
+	}, true
+}
+
+// videoDirIndex returns the index of the video directory containing path
+func videoDirIndex(cfg *config.Config, path string) (int, bool) {
+	for dirIndex, videoDir := range cfg.VideoDirs {
+		relPath, err := filepath.Rel(videoDir, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return dirIndex, true
+	}
+	return 0, false
+}
+
+// isInThumbnailDir reports whether path is the thumbnail directory or inside it
+func isInThumbnailDir(cfg *config.Config, path string) bool {
+	thumbnailDir, err := filepath.Abs(cfg.ThumbnailDir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return absPath == thumbnailDir || strings.HasPrefix(absPath, thumbnailDir+string(filepath.Separator))
+}
+
+// isVideoFile reports whether the filename has one of the configured video extensions
+func isVideoFile(name string, cfg *config.Config) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, videoExt := range cfg.VideoExtensions {
+		if ext == videoExt {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVideoPath checks that a prefixed path is usable as a stats/cache key
+// Paths that are too long or not valid UTF-8 (which JSON would silently rewrite)
+// are rejected
+func validateVideoPath(prefixedPath string, cfg *config.Config) error {
+	if cfg.MaxPathLength > 0 && len(prefixedPath) > cfg.MaxPathLength {
+		return fmt.Errorf("path too long (%d bytes, max %d)", len(prefixedPath), cfg.MaxPathLength)
+	}
+	if !utf8.ValidString(prefixedPath) {
+		return fmt.Errorf("path is not valid UTF-8")
+	}
+	return nil
+}
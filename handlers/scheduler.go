@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// generationPriority orders the generations waiting for an ffmpeg slot
+type generationPriority int
+
+const (
+	// priorityBatch is background generation nobody is waiting on
+	priorityBatch generationPriority = iota
+	// priorityOnDemand is generation a client is waiting on, which goes first
+	priorityOnDemand
+)
+
+// errGenerationBusy is returned when an on-demand generation waited too long for a slot
+var errGenerationBusy = errors.New("timed out waiting for a free ffmpeg slot")
+
+// errPreempted cancels a batch generation whose slot was taken by an on-demand one
+var errPreempted = errors.New("preempted by an on-demand generation")
+
+// generationScheduler hands out the cfg.MaxConcurrentFFmpeg ffmpeg slots shared by
+// batch and on-demand generation. Waiting on-demand generations always get the
+// next free slot before any batch one. If none is free when one arrives, the most
+// recently started batch generation is cancelled, its partial output is cleaned
+// up as for any cancelled generation, and it goes back to waiting for a slot to
+// start over; it isn't counted as failed. Without a limit everything runs at once.
+type generationScheduler struct {
+	mu      sync.Mutex
+	limit   int
+	running []*scheduledRun // in start order
+	waiting [priorityOnDemand + 1][]*scheduledRun
+}
+
+// scheduledRun is one generation waiting for or holding a slot
+type scheduledRun struct {
+	priority  generationPriority
+	parent    context.Context
+	ctx       context.Context // set when the slot is granted
+	cancel    context.CancelCauseFunc
+	ready     chan struct{}
+	preempted bool
+}
+
+var (
+	schedulerOnce sync.Once
+	scheduler     *generationScheduler
+)
+
+// generationSlots returns the scheduler, sized from cfg on first use
+func generationSlots(cfg *config.Config) *generationScheduler {
+	schedulerOnce.Do(func() {
+		scheduler = &generationScheduler{limit: cfg.MaxConcurrentFFmpeg}
+	})
+	return scheduler
+}
+
+// acquire waits for a slot, giving up after wait (0 waits forever) or when ctx is done
+func (s *generationScheduler) acquire(ctx context.Context, priority generationPriority, wait time.Duration) (*scheduledRun, error) {
+	run := &scheduledRun{priority: priority, parent: ctx, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	s.waiting[priority] = append(s.waiting[priority], run)
+	s.grant()
+	if priority == priorityOnDemand {
+		s.preempt()
+	}
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case <-run.ready:
+		return run, nil
+	case <-timeout:
+		err = errGenerationBusy
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-run.ready:
+		// Granted while giving up, hand the slot on
+		s.remove(run)
+	default:
+		s.dequeue(run)
+	}
+	return nil, err
+}
+
+// release frees the slot of a finished run
+func (s *generationScheduler) release(run *scheduledRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(run)
+}
+
+// grant starts waiting runs, highest priority first, while slots are free
+func (s *generationScheduler) grant() {
+	for priority := priorityOnDemand; priority >= priorityBatch; priority-- {
+		for len(s.waiting[priority]) > 0 && (s.limit <= 0 || len(s.running) < s.limit) {
+			run := s.waiting[priority][0]
+			s.waiting[priority] = s.waiting[priority][1:]
+			run.ctx, run.cancel = context.WithCancelCause(run.parent)
+			s.running = append(s.running, run)
+			close(run.ready)
+		}
+	}
+}
+
+// preempt cancels the newest batch runs until every waiting on-demand run has a
+// slot coming free. Their slots are handed on when the batch workers release them.
+func (s *generationScheduler) preempt() {
+	freeing := 0
+	for _, run := range s.running {
+		if run.preempted {
+			freeing++
+		}
+	}
+	for i := len(s.running) - 1; i >= 0 && freeing < len(s.waiting[priorityOnDemand]); i-- {
+		run := s.running[i]
+		if run.priority == priorityBatch && !run.preempted {
+			run.preempted = true
+			run.cancel(errPreempted)
+			freeing++
+		}
+	}
+}
+
+// remove drops a run from the running list and passes its slot on
+func (s *generationScheduler) remove(run *scheduledRun) {
+	for i, r := range s.running {
+		if r == run {
+			s.running = append(s.running[:i], s.running[i+1:]...)
+			break
+		}
+	}
+	run.cancel(context.Canceled)
+	s.grant()
+}
+
+// dequeue drops a run that gave up waiting
+func (s *generationScheduler) dequeue(run *scheduledRun) {
+	queue := s.waiting[run.priority]
+	for i, r := range queue {
+		if r == run {
+			s.waiting[run.priority] = append(queue[:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// generateBatch runs one video of a batch job in a scheduler slot, bounded by
+// generateWithTimeout. A preempted run waits for another slot and runs again,
+// which skips whatever it already finished.
+func generateBatch(ctx context.Context, cfg *config.Config, videoPath string, generate func(context.Context) error) error {
+	slots := generationSlots(cfg)
+	for {
+		run, err := slots.acquire(ctx, priorityBatch, 0)
+		if err != nil {
+			return err
+		}
+		err = generateWithTimeout(run.ctx, cfg, videoPath, generate)
+		preempted := errors.Is(context.Cause(run.ctx), errPreempted)
+		slots.release(run)
+		if !preempted || ctx.Err() != nil {
+			return err
+		}
+		slog.Info("⏸️  Batch generation preempted, requeued", "path", videoPath)
+	}
+}
+
+// generateOnDemand runs a generation for a waiting client ahead of any batch
+// work, giving up with errGenerationBusy after cfg.FFmpegQueueTimeout
+func generateOnDemand(ctx context.Context, cfg *config.Config, videoPath string, generate func(context.Context) error) error {
+	slots := generationSlots(cfg)
+	run, err := slots.acquire(ctx, priorityOnDemand, cfg.FFmpegQueueTimeout)
+	if err != nil {
+		return err
+	}
+	defer slots.release(run)
+	return generateWithTimeout(run.ctx, cfg, videoPath, generate)
+}
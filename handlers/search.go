@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+)
+
+// searchTokens splits a name or query into lowercase words
+// Separators common in filenames (dots, dashes, underscores, brackets) split words too
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchScore scores how well name matches query
+// Exact mode is a plain case-insensitive substring match (score 1).
+// Fuzzy mode matches each query word independently, in any order, against the name:
+// a word prefix scores highest, then a substring, then a word within a small edit distance,
+// then the letters appearing in order. All query words must match.
+// The score is the average word score in (0, 1]
+func searchScore(name, query string, fuzzy bool) (float64, bool) {
+	lowerName := strings.ToLower(name)
+	if !fuzzy {
+		return 1, strings.Contains(lowerName, strings.ToLower(query))
+	}
+
+	queryTokens := searchTokens(query)
+	if len(queryTokens) == 0 {
+		return 1, true
+	}
+	nameTokens := searchTokens(name)
+
+	total := 0.0
+	for _, token := range queryTokens {
+		score := tokenScore(lowerName, nameTokens, token)
+		if score == 0 {
+			return 0, false
+		}
+		total += score
+	}
+	return total / float64(len(queryTokens)), true
+}
+
+// tokenScore scores a single query word against a name
+func tokenScore(lowerName string, nameTokens []string, token string) float64 {
+	for _, word := range nameTokens {
+		if strings.HasPrefix(word, token) {
+			return 1.0
+		}
+	}
+	if strings.Contains(lowerName, token) {
+		return 0.8
+	}
+
+	// Allow one typo in short words, two in longer ones
+	maxDistance := 1
+	if len([]rune(token)) > 6 {
+		maxDistance = 2
+	}
+	if len([]rune(token)) > 2 {
+		best := maxDistance + 1
+		for _, word := range nameTokens {
+			if d := levenshtein(word, token); d < best {
+				best = d
+			}
+		}
+		if best <= maxDistance {
+			return 0.6 - 0.1*float64(best)
+		}
+	}
+
+	if isSubsequence(token, lowerName) {
+		return 0.2
+	}
+	return 0
+}
+
+// isSubsequence reports whether all runes of needle appear in haystack in order
+func isSubsequence(needle, haystack string) bool {
+	rest := []rune(needle)
+	for _, r := range haystack {
+		if len(rest) == 0 {
+			break
+		}
+		if r == rest[0] {
+			rest = rest[1:]
+		}
+	}
+	return len(rest) == 0
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
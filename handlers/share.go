@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// shareCookie holds the share token of a visitor who opened a share link
+const shareCookie = "share_token"
+
+// shareStore validates share tokens in AuthMiddleware, nil disables share links
+var shareStore *storage.PlaylistStorage
+
+// EnableShareLinks lets AuthMiddleware accept playlist share tokens
+func EnableShareLinks(store *storage.PlaylistStorage) {
+	shareStore = store
+}
+
+// sharePages are the pages a share token may open
+var sharePages = map[string]bool{
+	"/playlist.html": true,
+	"/player":        true,
+}
+
+// authorizeShare checks that a share token is still active and that the request
+// only reads the shared playlist or one of its videos. It writes the error response itself
+func authorizeShare(c *gin.Context, claims *Claims) bool {
+	if shareStore == nil || !shareStore.ShareActive(claims.ID, claims.Share) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Share link is no longer valid", "reason": "invalid"})
+		return false
+	}
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Share links are read-only"})
+		return false
+	}
+
+	route := c.FullPath()
+	switch {
+	case sharePages[route]:
+		return true
+	case (route == "/api/playlists/:id" || route == "/api/playlists/:id/cover") && c.Param("id") == claims.Share:
+		return true
+	}
+
+	if videoPath, ok := sharedVideoPath(c); ok && shareStore.HasVideo(claims.Share, videoPath, claims.Username) {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": "Share link doesn't grant access to this resource"})
+	return false
+}
+
+// sharedVideoPath returns the video a media request is for
+func sharedVideoPath(c *gin.Context) (string, bool) {
+	switch c.FullPath() {
+	case "/api/video/*filename":
+		return strings.TrimPrefix(c.Param("filename"), "/"), true
+	case "/api/subtitles/*filename":
+		filename := strings.TrimPrefix(c.Param("filename"), "/")
+		if filename == "tracks" || filename == "extract" {
+			return c.Query("video"), true
+		}
+		return filename, true
+	case "/api/thumbnail", "/api/preview", "/api/storyboard":
+		return c.Query("video"), true
+	}
+	return "", false
+}
+
+// signShareToken creates the token of a share
+// It carries the sharer's username, so smart playlist rules on likes match
+// what the sharer sees.
+func signShareToken(share *storage.PlaylistShare, username string) (string, error) {
+	claims := Claims{
+		Username: username,
+		Share:    share.PlaylistID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       share.ID,
+			IssuedAt: jwt.NewNumericDate(share.CreatedAt),
+		},
+	}
+	if share.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*share.ExpiresAt)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// CreateShareHandler mints a read-only share link for a playlist
+// An optional expiresIn duration (e.g. "72h") limits how long the link works
+func CreateShareHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req struct {
+			ExpiresIn string `json:"expiresIn"`
+		}
+		// The body is optional
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+				return
+			}
+		}
+
+		var expiresAt *time.Time
+		if req.ExpiresIn != "" {
+			ttl, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || ttl <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresIn"})
+				return
+			}
+			t := time.Now().Add(ttl)
+			expiresAt = &t
+		}
+
+		share := playlistStore.CreateShare(id, expiresAt)
+		if share == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+
+		token, err := signShareToken(share, c.GetString("username"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"share": share,
+			"token": token,
+			"url":   "/share/" + token,
+		})
+	}
+}
+
+// ListSharesHandler lists the share links of a playlist
+func ListSharesHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"shares": playlistStore.ListShares(c.Param("id"))})
+	}
+}
+
+// RevokeShareHandler disables a share link
+func RevokeShareHandler(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !playlistStore.RevokeShare(c.Param("id"), c.Param("shareId")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Share revoked"})
+	}
+}
+
+// SharePage opens a share link: it stores the share token in a cookie
+// and redirects to the shared playlist
+func SharePage(cfg *config.Config, playlistStore *storage.PlaylistStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Param("token")
+
+		claims, err := parseToken(token)
+		if err != nil || claims.Share == "" || !playlistStore.ShareActive(claims.ID, claims.Share) {
+			c.HTML(http.StatusNotFound, "404.html", nil)
+			return
+		}
+
+		maxAge := 0 // Session cookie when the share doesn't expire
+		if claims.ExpiresAt != nil {
+			maxAge = int(time.Until(claims.ExpiresAt.Time).Seconds())
+		}
+		c.SetCookie(shareCookie, token, maxAge, "/", "", c.Request.TLS != nil, true)
+		c.Redirect(http.StatusFound, "/playlist.html?id="+claims.Share)
+	}
+}
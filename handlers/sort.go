@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"cmp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// videoCompare compares two videos on one key, returning <0, 0 or >0
+type videoCompare func(a, b *Video) int
+
+// sortKeys are the supported sort fields, all comparing in ascending order
+var sortKeys = map[string]videoCompare{
+	"modified":   func(a, b *Video) int { return strings.Compare(a.Modified, b.Modified) },
+	"added":      func(a, b *Video) int { return strings.Compare(a.Added, b.Added) },
+	"views":      func(a, b *Video) int { return cmp.Compare(a.Views, b.Views) },
+	"likes":      func(a, b *Video) int { return cmp.Compare(a.Likes, b.Likes) },
+	"hotness":    func(a, b *Video) int { return cmp.Compare(a.Hotness, b.Hotness) },
+	"name":       func(a, b *Video) int { return naturalCompare(a.Name, b.Name) },
+	"size":       func(a, b *Video) int { return cmp.Compare(a.Size, b.Size) },
+	"duration":   func(a, b *Video) int { return cmp.Compare(a.DurationSec, b.DurationSec) },
+	"watchtime":  func(a, b *Video) int { return cmp.Compare(a.WatchSeconds, b.WatchSeconds) },
+	"relevance":  func(a, b *Video) int { return cmp.Compare(a.Relevance, b.Relevance) },
+	"bookmarked": func(a, b *Video) int { return cmp.Compare(boolInt(a.Bookmarked), boolInt(b.Bookmarked)) },
+}
+
+// tieBreakDescending lists tie-break keys that prefer larger values (newer, bigger)
+var tieBreakDescending = map[string]bool{
+	"modified": true,
+	"size":     true,
+}
+
+// videoLess builds the less function for sorting videos by sortBy in the given order
+func videoLess(cfg *config.Config, videos []Video, sortBy, order string) func(i, j int) bool {
+	compare := videoOrder(cfg, sortBy, order)
+	return func(i, j int) bool {
+		return compare(&videos[i], &videos[j]) < 0
+	}
+}
+
+// videoOrder builds the comparison used to order videos by sortBy in the given order
+// Ties are broken by cfg.SortTieBreak (name A-Z, newest first or largest first),
+// then by path, so the ordering is always deterministic
+func videoOrder(cfg *config.Config, sortBy, order string) videoCompare {
+	primary, ok := sortKeys[sortBy]
+	if !ok {
+		sortBy = "modified"
+		primary = sortKeys[sortBy]
+	}
+	desc := order != "asc"
+
+	tieBreak, ok := sortKeys[cfg.SortTieBreak]
+	if !ok {
+		tieBreak = sortKeys["name"]
+	}
+	tieDesc := tieBreakDescending[cfg.SortTieBreak]
+
+	return func(a, b *Video) int {
+		if c := primary(a, b); c != 0 {
+			if desc {
+				return -c
+			}
+			return c
+		}
+		if c := tieBreak(a, b); c != 0 {
+			if tieDesc {
+				return -c
+			}
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	}
+}
+
+// naturalCompare compares names case-insensitively with digit runs compared by
+// numeric value, so "Episode 2" sorts before "Episode 10"
+// Names that only differ in zero-padding order the less padded one first, and
+// names that are otherwise equal fall back to a plain comparison.
+func naturalCompare(a, b string) int {
+	i, j := 0, 0
+	padding := 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			ei, ej := digitRunEnd(a, i), digitRunEnd(b, j)
+			ta, tb := strings.TrimLeft(a[i:ei], "0"), strings.TrimLeft(b[j:ej], "0")
+			// Without leading zeros, a longer run is a larger number
+			if c := cmp.Compare(len(ta), len(tb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			if padding == 0 {
+				padding = cmp.Compare(ei-i, ej-j)
+			}
+			i, j = ei, ej
+			continue
+		}
+
+		ra, na := utf8.DecodeRuneInString(a[i:])
+		rb, nb := utf8.DecodeRuneInString(b[j:])
+		if c := cmp.Compare(unicode.ToLower(ra), unicode.ToLower(rb)); c != 0 {
+			return c
+		}
+		i += na
+		j += nb
+	}
+	if c := cmp.Compare(len(a)-i, len(b)-j); c != 0 {
+		return c
+	}
+	if padding != 0 {
+		return padding
+	}
+	return strings.Compare(a, b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitRunEnd returns the index after the run of digits starting at i
+func digitRunEnd(s string, i int) int {
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+func TestVideoOrderName(t *testing.T) {
+	cfg := &config.Config{SortTieBreak: "name"}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"asc", []string{"0:Alpha.mp4", "0:beta.mp4", "0:Episode 2.mp4", "0:Episode 10.mp4"}},
+		{"desc", []string{"0:Episode 10.mp4", "0:Episode 2.mp4", "0:beta.mp4", "0:Alpha.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			videos := []*Video{
+				{Name: "Episode 10.mp4", Path: "0:Episode 10.mp4"},
+				{Name: "beta.mp4", Path: "0:beta.mp4"},
+				{Name: "Episode 2.mp4", Path: "0:Episode 2.mp4"},
+				{Name: "Alpha.mp4", Path: "0:Alpha.mp4"},
+			}
+			slices.SortFunc(videos, videoOrder(cfg, "name", tt.order))
+
+			got := make([]string, len(videos))
+			for i, v := range videos {
+				got[i] = v.Path
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sort=name order=%s: got %v, want %v", tt.order, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"Episode 2", "Episode 10", -1},
+		{"Episode 10", "Episode 2", 1},
+		{"ep2", "ep02", -1},
+		{"ep02", "ep10", -1},
+		{"ep2", "ep10", -1},
+		{"s1e10", "s1e9", 1},
+		{"s2e1", "s10e1", -1},
+		{"a1b2", "a1b10", -1},
+		{"track", "track1", -1},
+		{"10 items", "9 items", 1},
+		{"apple", "Banana", -1},
+		{"EPISODE 3", "episode 4", -1},
+		{"Abc", "abc", -1},
+		{"Episode 2", "Episode 2", 0},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := naturalCompare(tt.a, tt.b); cmp.Compare(got, 0) != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// Storyboard layout: a grid of small frames sampled evenly over the video
+const (
+	storyboardColumns    = 10
+	storyboardRows       = 10
+	storyboardTileWidth  = 160
+	storyboardTileHeight = 90
+)
+
+// storyboardFile returns the path of a cached storyboard sprite ("jpg") or cue file ("vtt")
+func storyboardFile(cfg *config.Config, hash, ext string) string {
+	return cacheFile(cfg, hash+".storyboard."+ext)
+}
+
+// generateStoryboard renders the sprite sheet and WebVTT cues for a video
+// Only keyframes are decoded, which is much faster and close enough for scrubbing
+func generateStoryboard(ctx context.Context, cfg *config.Config, absVideoPath, hash string) error {
+	// One generation per content hash at a time; whoever waited finds the cues below
+	unlock, err := generationLocks.lock(ctx, "storyboard:"+hash)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vttPath := storyboardFile(cfg, hash, "vtt")
+	if _, err := os.Stat(vttPath); err == nil {
+		return nil
+	}
+
+	duration, err := probeDurationSeconds(ctx, absVideoPath)
+	if err != nil {
+		return err
+	}
+	if duration < 1 {
+		return fmt.Errorf("unknown duration")
+	}
+
+	frames := storyboardColumns * storyboardRows
+	interval := duration / float64(frames)
+
+	spritePath := storyboardFile(cfg, hash, "jpg")
+	if err := ensureCacheDir(spritePath); err != nil {
+		return err
+	}
+	tmpSprite, err := createTempFile(spritePath, ".jpg")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpSprite)
+
+	filter := fmt.Sprintf(
+		"fps=1/%f,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		interval, storyboardTileWidth, storyboardTileHeight, storyboardTileWidth, storyboardTileHeight,
+		storyboardColumns, storyboardRows,
+	)
+	cmd := ffmpegCommand(ctx,
+		"-skip_frame", "nokey",
+		"-i", absVideoPath,
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "4",
+		"-y", tmpSprite,
+	)
+	if err := runFFmpeg(cmd); err != nil {
+		return err
+	}
+
+	// Cues reference the sprite by file name, GetStoryboard rewrites them to API URLs
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	spriteName := filepath.Base(spritePath)
+	for i := 0; i < frames; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		x := (i % storyboardColumns) * storyboardTileWidth
+		y := (i / storyboardColumns) * storyboardTileHeight
+		fmt.Fprintf(&vtt, "%s --> %s\n%s#xywh=%d,%d,%d,%d\n\n",
+			vttTimestamp(start), vttTimestamp(end), spriteName, x, y, storyboardTileWidth, storyboardTileHeight)
+	}
+
+	tmpVTT, err := createTempFile(vttPath, "")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmpVTT, []byte(vtt.String()), 0644); err != nil {
+		os.Remove(tmpVTT)
+		return err
+	}
+	if err := os.Rename(tmpSprite, spritePath); err != nil {
+		os.Remove(tmpVTT)
+		return err
+	}
+	// The cue file goes last, its presence marks the storyboard complete
+	if err := os.Rename(tmpVTT, vttPath); err != nil {
+		os.Remove(tmpVTT)
+		return err
+	}
+	return nil
+}
+
+// createTempFile creates an empty temp file next to path, named after it with a
+// random part and ending in ext, so concurrent writers never share one
+func createTempFile(path, ext string) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp"+ext)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp (HH:MM:SS.mmm)
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// GetStoryboard serves the seek bar storyboard of a video
+// Without parameters it returns the WebVTT cues; with ?sprite=1 the sprite sheet they point into.
+// Both are generated on first request and cached by content hash next to the thumbnail
+func GetStoryboard(cfg *config.Config, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoPath := c.Query("video")
+		if videoPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No video specified"})
+			return
+		}
+
+		absVideoPath, err := parseVideoPath(videoPath, cfg)
+		if err == nil {
+			absVideoPath, err = filepath.Abs(absVideoPath)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video path"})
+			return
+		}
+
+		if !isInVideoDirs(cfg, absVideoPath) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		if _, err := os.Stat(absVideoPath); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+
+		hash, err := index.ContentHash(videoPath, absVideoPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
+			return
+		}
+
+		vttPath := storyboardFile(cfg, hash, "vtt")
+		if _, err := os.Stat(vttPath); err != nil {
+			err := generateOnDemand(c.Request.Context(), cfg, videoPath, func(ctx context.Context) error {
+				return generateStoryboard(ctx, cfg, absVideoPath, hash)
+			})
+			if err != nil {
+				requestLog(c).Error("❌ Failed to generate storyboard", "path", videoPath, "error", err)
+				generationFailed(c, cfg, "Failed to generate storyboard", err)
+				return
+			}
+		}
+
+		if c.Query("sprite") == "1" {
+			c.File(storyboardFile(cfg, hash, "jpg"))
+			return
+		}
+
+		data, err := os.ReadFile(vttPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read storyboard"})
+			return
+		}
+
+		spriteURL := "/api/storyboard?video=" + url.QueryEscape(videoPath) + "&sprite=1"
+		cues := strings.ReplaceAll(string(data), filepath.Base(storyboardFile(cfg, hash, "jpg")), spriteURL)
+		c.Data(http.StatusOK, "text/vtt; charset=utf-8", []byte(cues))
+	}
+}
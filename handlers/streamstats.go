@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+)
+
+// maxRecentStreams is how many finished streams are kept for inspection
+const maxRecentStreams = 100
+
+// StreamRecord describes a single finished stream request
+type StreamRecord struct {
+	Path       string    `json:"path"`
+	Username   string    `json:"username,omitempty"`
+	Range      string    `json:"range,omitempty"` // Requested byte range, empty for full reads
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"` // Bytes actually written to the client
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// videoStreamTotals aggregates streams for a single video
+type videoStreamTotals struct {
+	Streams int   `json:"streams"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// StreamStats collects per-request streaming statistics in memory
+type StreamStats struct {
+	mu           sync.Mutex
+	recent       []StreamRecord
+	totalStreams int
+	totalBytes   int64
+	perVideo     map[string]*videoStreamTotals
+}
+
+// NewStreamStats creates an empty stream statistics collector
+func NewStreamStats() *StreamStats {
+	return &StreamStats{perVideo: make(map[string]*videoStreamTotals)}
+}
+
+// Record adds a finished stream to the statistics
+func (s *StreamStats) Record(rec StreamRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalStreams++
+	s.totalBytes += rec.Bytes
+
+	totals := s.perVideo[rec.Path]
+	if totals == nil {
+		totals = &videoStreamTotals{}
+		s.perVideo[rec.Path] = totals
+	}
+	totals.Streams++
+	totals.Bytes += rec.Bytes
+
+	s.recent = append(s.recent, rec)
+	if len(s.recent) > maxRecentStreams {
+		s.recent = s.recent[len(s.recent)-maxRecentStreams:]
+	}
+}
+
+// StreamStatsHandler returns aggregate and recent streaming statistics
+func StreamStatsHandler(cfg *config.Config, stats *StreamStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats.mu.Lock()
+		defer stats.mu.Unlock()
+
+		// Newest first
+		recent := make([]StreamRecord, len(stats.recent))
+		for i, rec := range stats.recent {
+			recent[len(stats.recent)-1-i] = rec
+		}
+
+		perVideo := make(map[string]videoStreamTotals, len(stats.perVideo))
+		for path, totals := range stats.perVideo {
+			perVideo[path] = *totals
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"totalStreams": stats.totalStreams,
+			"totalBytes":   stats.totalBytes,
+			"videos":       perVideo,
+			"recent":       recent,
+		})
+	}
+}
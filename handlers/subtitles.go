@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// subtitleExtensions are the sibling subtitle formats looked for, in order of preference
+var subtitleExtensions = []string{".vtt", ".srt", ".ass"}
+
+// srtTimestamp matches SRT cue timestamps (00:01:02,345), which WebVTT writes with a dot
+var srtTimestamp = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+
+// findSubtitle returns the subtitle file next to a video with the same base name
+// e.g. "Movie.mkv" -> "Movie.vtt", "Movie.srt" or "Movie.ass"
+func findSubtitle(absVideoPath string) (string, bool) {
+	base := strings.TrimSuffix(absVideoPath, filepath.Ext(absVideoPath))
+	for _, ext := range subtitleExtensions {
+		for _, candidate := range []string{base + ext, base + strings.ToUpper(ext)} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// srtToVTT converts SRT subtitles to WebVTT
+func srtToVTT(srt []byte) []byte {
+	srt = bytes.TrimPrefix(srt, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	srt = bytes.ReplaceAll(srt, []byte("\r\n"), []byte("\n"))
+
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+	for _, line := range bytes.Split(srt, []byte("\n")) {
+		if bytes.Contains(line, []byte("-->")) {
+			line = srtTimestamp.ReplaceAll(line, []byte("$1.$2"))
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// imageSubtitleCodecs are bitmap subtitle formats that can't be converted to WebVTT
+var imageSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// SubtitleTrack is an embedded subtitle stream
+type SubtitleTrack struct {
+	Track    int    `json:"track"` // Index among the subtitle streams, used for extraction
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default"`
+	Text     bool   `json:"text"` // Text based, so it can be extracted to WebVTT
+}
+
+// probeSubtitleTracks lists the embedded subtitle streams of a video using ffprobe
+func probeSubtitleTracks(absPath string) ([]SubtitleTrack, error) {
+	cmd := ffprobeCommand(context.Background(),
+		"-v", "error",
+		"-select_streams", "s",
+		"-show_entries", "stream=codec_name:stream_tags=language,title:stream_disposition=default",
+		"-of", "json",
+		absPath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName   string            `json:"codec_name"`
+			Tags        map[string]string `json:"tags"`
+			Disposition map[string]int    `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+
+	tracks := make([]SubtitleTrack, 0, len(probe.Streams))
+	for i, stream := range probe.Streams {
+		tracks = append(tracks, SubtitleTrack{
+			Track:    i,
+			Codec:    stream.CodecName,
+			Language: stream.Tags["language"],
+			Title:    stream.Tags["title"],
+			Default:  stream.Disposition["default"] == 1,
+			Text:     !imageSubtitleCodecs[stream.CodecName],
+		})
+	}
+	return tracks, nil
+}
+
+// extractSubtitleTrack converts an embedded subtitle stream to a WebVTT file
+func extractSubtitleTrack(absPath string, track int, outputPath string) error {
+	tempPath := outputPath + ".tmp"
+	cmd := ffmpegCommand(context.Background(),
+		"-i", absPath,
+		"-map", fmt.Sprintf("0:s:%d", track),
+		"-f", "webvtt",
+		"-y",
+		tempPath,
+	)
+	if err := cmd.Run(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, outputPath)
+}
+
+// subtitleVideoPath resolves a prefixed video path and applies the video directory check
+// On failure the error response has already been written
+func subtitleVideoPath(c *gin.Context, cfg *config.Config, prefixedPath string) (string, bool) {
+	absPath, err := parseVideoPath(prefixedPath, cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return "", false
+	}
+
+	// Security check - ensure path is within one of the video directories
+	absPath, err = filepath.Abs(absPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return "", false
+	}
+	if !isInVideoDirs(cfg, absPath) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return "", false
+	}
+	return absPath, true
+}
+
+// GetSubtitles serves the sibling subtitle file of a video
+// SRT is converted to WebVTT so it can be used directly in a <track> element.
+// /api/subtitles/tracks and /api/subtitles/extract are handled here as well, since they
+// can't be registered next to the wildcard route (prefixed video paths always contain ':')
+func GetSubtitles(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filename := strings.TrimPrefix(c.Param("filename"), "/")
+
+		switch filename {
+		case "tracks":
+			getSubtitleTracks(c, cfg)
+			return
+		case "extract":
+			extractSubtitles(c, cfg)
+			return
+		}
+
+		absPath, ok := subtitleVideoPath(c, cfg, filename)
+		if !ok {
+			return
+		}
+
+		subtitlePath, ok := findSubtitle(absPath)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subtitles not found"})
+			return
+		}
+
+		data, err := os.ReadFile(subtitlePath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read subtitles"})
+			return
+		}
+
+		switch strings.ToLower(filepath.Ext(subtitlePath)) {
+		case ".srt":
+			c.Data(http.StatusOK, "text/vtt; charset=utf-8", srtToVTT(data))
+		case ".ass":
+			c.Data(http.StatusOK, "text/x-ssa; charset=utf-8", data)
+		default:
+			c.Data(http.StatusOK, "text/vtt; charset=utf-8", data)
+		}
+	}
+}
+
+// getSubtitleTracks lists the embedded subtitle tracks of ?video=
+func getSubtitleTracks(c *gin.Context, cfg *config.Config) {
+	absPath, ok := subtitleVideoPath(c, cfg, c.Query("video"))
+	if !ok {
+		return
+	}
+
+	tracks, err := probeSubtitleTracks(absPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to probe subtitle tracks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"video":  c.Query("video"),
+		"tracks": tracks,
+	})
+}
+
+// extractSubtitles serves embedded subtitle ?track=N of ?video= as WebVTT
+// Extracted tracks are cached in the thumbnail directory by content hash and track index
+func extractSubtitles(c *gin.Context, cfg *config.Config) {
+	absPath, ok := subtitleVideoPath(c, cfg, c.Query("video"))
+	if !ok {
+		return
+	}
+
+	track, err := strconv.Atoi(c.Query("track"))
+	if err != nil || track < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track"})
+		return
+	}
+
+	contentHash, err := storage.GetFileContentHash(absPath, cfg.HashMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
+		return
+	}
+
+	vttPath := cacheFile(cfg, fmt.Sprintf("%s.sub%d.vtt", contentHash, track))
+	if _, err := os.Stat(vttPath); err != nil {
+		tracks, err := probeSubtitleTracks(absPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to probe subtitle tracks"})
+			return
+		}
+		if track >= len(tracks) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle track not found"})
+			return
+		}
+		if !tracks[track].Text {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Image-based subtitles can't be converted to WebVTT"})
+			return
+		}
+
+		if err := ensureCacheDir(vttPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cache directory"})
+			return
+		}
+		if err := extractSubtitleTrack(absPath, track, vttPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract subtitles"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/vtt; charset=utf-8")
+	c.File(vttPath)
+}
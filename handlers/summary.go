@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// LibrarySummary aggregates the library or one of its directories
+type LibrarySummary struct {
+	Videos            int     `json:"videos"`
+	TotalSize         int64   `json:"totalSize"`
+	TotalDurationSec  int64   `json:"totalDurationSec"`
+	TotalDuration     string  `json:"totalDuration"`
+	UnknownDuration   int     `json:"unknownDuration"` // Videos whose duration couldn't be read
+	Views             int     `json:"views"`
+	Likes             int     `json:"likes"`
+	MissingThumbnails int     `json:"missingThumbnails"`
+	MissingPreviews   int     `json:"missingPreviews"`
+	ThumbnailCoverage float64 `json:"thumbnailCoverage"` // Percentage of videos with a thumbnail
+	PreviewCoverage   float64 `json:"previewCoverage"`
+}
+
+// DirectorySummary is the summary of one entry of VideoDirs
+type DirectorySummary struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	LibrarySummary
+}
+
+// hasThumbnail reports whether a recorded thumbnail hash points at an existing file
+func hasThumbnail(cfg *config.Config, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := os.Stat(thumbnailFile(cfg, hash, "", "jpg"))
+	return err == nil
+}
+
+// hasPreview reports whether a recorded preview hash points at an existing
+// preview with the configured options
+func hasPreview(cfg *config.Config, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := os.Stat(previewFile(cfg, hash, defaultPreviewOptions(cfg)))
+	return err == nil
+}
+
+// add counts one video into the summary
+func (s *LibrarySummary) add(iv *IndexedVideo, stats *storage.VideoStats, thumbnail, preview bool) {
+	s.Videos++
+	s.TotalSize += iv.Size
+	if iv.Duration > 0 {
+		s.TotalDurationSec += int64(iv.Duration.Seconds())
+	} else {
+		s.UnknownDuration++
+	}
+	if stats != nil {
+		s.Views += stats.Views
+		s.Likes += stats.Likes
+	}
+	if !thumbnail {
+		s.MissingThumbnails++
+	}
+	if !preview {
+		s.MissingPreviews++
+	}
+}
+
+// finish fills in the derived fields
+func (s *LibrarySummary) finish() {
+	s.TotalDuration = FormatDuration(time.Duration(s.TotalDurationSec) * time.Second)
+	s.ThumbnailCoverage, s.PreviewCoverage = 100, 100
+	if s.Videos > 0 {
+		s.ThumbnailCoverage = coverage(s.Videos-s.MissingThumbnails, s.Videos)
+		s.PreviewCoverage = coverage(s.Videos-s.MissingPreviews, s.Videos)
+	}
+}
+
+// coverage returns done as a percentage of total, rounded to one decimal
+func coverage(done, total int) float64 {
+	return float64(done*1000/total) / 10
+}
+
+// StatsSummaryHandler returns aggregate statistics for the whole library and per directory
+// Everything comes from the index and the database; only the generated files are
+// checked on disk. Missing previews are counted against the configured preview options.
+func StatsSummaryHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allStats := store.GetAllStats("")
+		hashes := store.GetAllGeneratedHashes()
+
+		var total LibrarySummary
+		dirs := make([]DirectorySummary, len(cfg.VideoDirs))
+		for i, dir := range cfg.VideoDirs {
+			dirs[i] = DirectorySummary{Index: i, Path: dir, Name: filepath.Base(dir)}
+		}
+
+		for _, iv := range index.Videos() {
+			h := hashes[iv.Path]
+			thumbnail := hasThumbnail(cfg, h.Thumbnail)
+			preview := hasPreview(cfg, h.Preview)
+			total.add(iv, allStats[iv.Path], thumbnail, preview)
+			if iv.DirIndex >= 0 && iv.DirIndex < len(dirs) {
+				dirs[iv.DirIndex].add(iv, allStats[iv.Path], thumbnail, preview)
+			}
+		}
+
+		total.finish()
+		for i := range dirs {
+			dirs[i].finish()
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"summary":         total,
+			"directories":     dirs,
+			"previewsEnabled": cfg.PreviewsEnabled,
+		})
+	}
+}
+
+// MissingVideo is a video without a usable thumbnail or preview
+type MissingVideo struct {
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	Hash   string `json:"hash,omitempty"` // Recorded hash whose file is gone
+	Reason string `json:"reason"`         // "no_hash" or "file_missing"
+}
+
+// MissingGenerationHandler lists videos whose thumbnail or preview was never
+// recorded or whose file is gone, selected by ?type=thumbnail|preview
+func MissingGenerationHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kind := c.DefaultQuery("type", "thumbnail")
+		var exists func(cfg *config.Config, hash string) bool
+		switch kind {
+		case "thumbnail":
+			exists = hasThumbnail
+		case "preview":
+			exists = hasPreview
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be thumbnail or preview"})
+			return
+		}
+
+		hashes := store.GetAllGeneratedHashes()
+		missing := make([]MissingVideo, 0)
+		for _, iv := range index.Videos() {
+			hash := hashes[iv.Path].Thumbnail
+			if kind == "preview" {
+				hash = hashes[iv.Path].Preview
+			}
+			switch {
+			case hash == "":
+				missing = append(missing, MissingVideo{Path: iv.Path, Name: iv.Name, Reason: "no_hash"})
+			case !exists(cfg, hash):
+				missing = append(missing, MissingVideo{Path: iv.Path, Name: iv.Name, Hash: hash, Reason: "file_missing"})
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"type":   kind,
+			"total":  len(missing),
+			"videos": missing,
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// TagsHandler lists tags
+// With ?video=<path> it returns that video's tags, otherwise all tags with counts
+func TagsHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if videoPath := c.Query("video"); videoPath != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"path": videoPath,
+				"tags": store.GetTags(videoPath),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": store.ListTags()})
+	}
+}
+
+// AddTagHandler tags a video
+func AddTagHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Path string `json:"path"`
+			Tag  string `json:"tag"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" || storage.NormalizeTag(req.Tag) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if !store.AddTag(req.Path, req.Tag) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add tag"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": store.GetTags(req.Path)})
+	}
+}
+
+// RemoveTagHandler removes a tag from a video
+func RemoveTagHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoPath := c.Query("video")
+		tag := c.Query("tag")
+
+		if videoPath == "" || tag == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Video path and tag are required"})
+			return
+		}
+
+		if !store.RemoveTag(videoPath, tag) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": store.GetTags(videoPath)})
+	}
+}
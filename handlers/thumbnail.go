@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kitsnail/streamlet/config"
@@ -26,9 +29,7 @@ type ThumbnailGenerator struct {
 
 // NewThumbnailGenerator creates a thumbnail generator
 func NewThumbnailGenerator(cfg *config.Config, storage *storage.Storage, workers int) *ThumbnailGenerator {
-	if workers < 1 {
-		workers = 4
-	}
+	workers = clampWorkers(workers, 4)
 	return &ThumbnailGenerator{cfg: cfg, storage: storage, workers: workers}
 }
 
@@ -38,42 +39,27 @@ func (tg *ThumbnailGenerator) SetProgressCallback(cb ProgressCallback) {
 }
 
 // GenerateAll generates thumbnails for all videos concurrently
-func (tg *ThumbnailGenerator) GenerateAll() error {
+// When ctx is cancelled, jobs already running finish but no new ones start
+func (tg *ThumbnailGenerator) GenerateAll(ctx context.Context) error {
 	// Ensure thumbnail directory exists
 	if err := os.MkdirAll(tg.cfg.ThumbnailDir, 0755); err != nil {
 		return err
 	}
 
-	// Find all video files from all directories
+	// Find all video files from all directories, leaving out quarantined ones
+	files := discoverVideos(tg.cfg)
+	skip := quarantined(tg.storage, files)
 	var videos []string
-	for dirIndex, videoDir := range tg.cfg.VideoDirs {
-		filepath.WalkDir(videoDir, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".mp4") {
-				// Skip macOS AppleDouble files
-				if strings.HasPrefix(d.Name(), "._") {
-					return nil
-				}
-				// Skip small files
-				info, err := d.Info()
-				if err != nil {
-					return nil
-				}
-				if info.Size() < 10*1024*1024 {
-					return nil
-				}
-				relPath, _ := filepath.Rel(videoDir, path)
-				// Prefix with directory index
-				prefixedPath := fmt.Sprintf("%d:%s", dirIndex, relPath)
-				videos = append(videos, prefixedPath)
-			}
-			return nil
-		})
+	for _, vf := range files {
+		if !skip[vf.Path] {
+			videos = append(videos, vf.Path)
+		}
+	}
+	if len(skip) > 0 {
+		slog.Info("⏭️  Skipping corrupt videos", "count", len(skip))
 	}
 
-	log.Printf("🖼️  Found %d videos, generating thumbnails with %d workers...", len(videos), tg.workers)
+	slog.Info("🖼️  Generating thumbnails", "videos", len(videos), "workers", tg.workers)
 
 	// Worker pool
 	jobs := make(chan string, len(videos))
@@ -90,7 +76,16 @@ func (tg *ThumbnailGenerator) GenerateAll() error {
 		go func(workerID int) {
 			defer wg.Done()
 			for videoPath := range jobs {
-				err := tg.generateThumbnail(videoPath)
+				if !waitForMemory(ctx, tg.cfg) {
+					return
+				}
+				err := generateBatch(ctx, tg.cfg, videoPath, func(ctx context.Context) error {
+					return tg.generateThumbnail(ctx, videoPath, "")
+				})
+				if ctx.Err() != nil {
+					// Interrupted jobs are reported as skipped, not failed
+					return
+				}
 				results <- struct {
 					path string
 					err  error
@@ -120,26 +115,39 @@ func (tg *ThumbnailGenerator) GenerateAll() error {
 
 	for result := range results {
 		if result.err != nil {
-			log.Printf("❌ Failed: %s - %v", result.path, result.err)
+			slog.Error("❌ Failed to generate thumbnail", "path", result.path, "error", result.err)
 			failed++
 		} else {
 			success++
 			if success%20 == 0 {
-				log.Printf("✅ Progress: %d/%d thumbnails generated", success, total)
+				slog.Info("✅ Progress", "kind", "thumbnail", "done", success, "total", total)
 			}
 		}
 		// Call progress callback
 		if tg.callback != nil {
-			tg.callback(total, success, failed)
+			tg.callback(total, success, failed, result.path)
 		}
 	}
 
-	log.Printf("🖼️  Thumbnail generation complete: %d success, %d failed", success, failed)
+	if err := ctx.Err(); err != nil {
+		slog.Info("🖼️  Thumbnail generation stopped", "success", success, "failed", failed, "skipped", total-success-failed)
+		return err
+	}
+
+	slog.Info("🖼️  Thumbnail generation complete", "success", success, "failed", failed)
 	return nil
 }
 
 // generateThumbnail generates a thumbnail for a single video
-func (tg *ThumbnailGenerator) generateThumbnail(prefixedPath string) error {
+// contentHash is the video's content hash when the caller already computed it,
+// empty to compute it here
+func (tg *ThumbnailGenerator) generateThumbnail(ctx context.Context, prefixedPath, contentHash string) error {
+	return tg.generateThumbnailAt(ctx, prefixedPath, contentHash, "")
+}
+
+// generateThumbnailAt is generateThumbnail taking the frame at position,
+// empty for the configured position of the video's directory
+func (tg *ThumbnailGenerator) generateThumbnailAt(ctx context.Context, prefixedPath, contentHash, position string) error {
 	// Parse prefixed path
 	absVideoPath, err := parseVideoPath(prefixedPath, tg.cfg)
 	if err != nil {
@@ -149,69 +157,179 @@ func (tg *ThumbnailGenerator) generateThumbnail(prefixedPath string) error {
 	// Get video name from path
 	videoName := filepath.Base(absVideoPath)
 
-	// Check database for existing hash
+	// Check database for existing hash, unless the caller knows the content changed
 	existingHash := tg.storage.GetThumbnailHash(prefixedPath)
-	if existingHash != "" {
-		thumbnailPath := filepath.Join(tg.cfg.ThumbnailDir, existingHash+".jpg")
-		if _, err := os.Stat(thumbnailPath); err == nil {
+	if existingHash != "" && (contentHash == "" || existingHash == contentHash) {
+		thumbnailPath := thumbnailFile(tg.cfg, existingHash, "", "jpg")
+		if info, err := os.Stat(thumbnailPath); err == nil && tg.isFresh(info) {
+			tg.generateSizes(ctx, existingHash, false)
 			return nil // Already exists with valid hash
 		}
 	}
 
 	// Calculate file content hash
-	contentHash, err := storage.GetFileContentHash(absVideoPath)
-	if err != nil {
-		return fmt.Errorf("failed to calculate content hash: %w", err)
+	if contentHash == "" {
+		contentHash, err = storage.GetFileContentHash(absVideoPath, tg.cfg.HashMode)
+		if err != nil {
+			return fmt.Errorf("failed to calculate content hash: %w", err)
+		}
 	}
 
-	thumbnailFilename := contentHash + ".jpg"
-	thumbnailPath := filepath.Join(tg.cfg.ThumbnailDir, thumbnailFilename)
+	thumbnailPath := thumbnailFile(tg.cfg, contentHash, "", "jpg")
+
+	// One generation per content hash at a time; whoever waited finds the file below
+	unlock, err := generationLocks.lock(ctx, "thumbnail:"+contentHash)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Check if thumbnail already exists (same content)
-	if _, err := os.Stat(thumbnailPath); err == nil {
+	if info, err := os.Stat(thumbnailPath); err == nil && tg.isFresh(info) {
 		// File exists, just update database
+		tg.generateSizes(ctx, contentHash, false)
 		tg.storage.SetThumbnailHash(prefixedPath, videoName, contentHash)
 		return nil
 	}
 
 	// Get video duration using ffprobe
-	durationCmd := exec.Command("ffprobe",
+	duration, err := probeDurationSeconds(ctx, absVideoPath)
+	if err != nil {
+		return err
+	}
+	if duration < 1 {
+		duration = 600 // Default to 10 minutes
+	}
+
+	if err := ensureCacheDir(thumbnailPath); err != nil {
+		return fmt.Errorf("failed to create thumbnail dir: %w", err)
+	}
+
+	// Take screenshot at the configured position for this video's directory
+	if position == "" {
+		position = thumbnailPosition(tg.cfg, absVideoPath)
+	}
+	if isSmartPosition(position) {
+		err = extractSmartFrame(ctx, absVideoPath, duration, thumbnailPath)
+	} else {
+		err = extractFrame(ctx, absVideoPath, thumbnailTimestamp(position, duration), thumbnailPath)
+	}
+	if err != nil {
+		os.Remove(thumbnailPath) // A killed ffmpeg can leave a partial frame
+		return err
+	}
+
+	// The frame changed, so resized variants must be redone too
+	tg.generateSizes(ctx, contentHash, true)
+
+	// Update database with new hash
+	tg.storage.SetThumbnailHash(prefixedPath, videoName, contentHash)
+	return nil
+}
+
+// probeDurationSeconds returns the duration of a video in seconds using ffprobe
+// Unparseable output gives 0 so callers can apply their own fallback
+func probeDurationSeconds(ctx context.Context, absVideoPath string) (float64, error) {
+	durationCmd := ffprobeCommand(ctx,
 		"-v", "error",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
 		absVideoPath,
 	)
-	durationOutput, err := durationCmd.Output()
+	durationOutput, err := outputFFmpeg(durationCmd)
 	if err != nil {
-		return fmt.Errorf("failed to get duration: %w", err)
+		return 0, fmt.Errorf("failed to get duration: %w", err)
 	}
 
 	durationStr := strings.TrimSpace(string(durationOutput))
 	duration, _ := strconv.ParseFloat(durationStr, 64)
-	if duration < 1 {
-		duration = 600 // Default to 10 minutes
+	return duration, nil
+}
+
+// generateSizes creates the resized variants configured in cfg.ThumbnailSizes
+// from the full-size thumbnail, plus WebP copies when cfg.ThumbnailFormat is "webp".
+// Existing variants are kept unless overwrite is set.
+// Failures are logged but not fatal since the full-size JPEG can still be served
+func (tg *ThumbnailGenerator) generateSizes(ctx context.Context, hash string, overwrite bool) {
+	source := thumbnailFile(tg.cfg, hash, "", "jpg")
+
+	sizes := append([]config.ThumbnailSize{{Name: ""}}, tg.cfg.ThumbnailSizes...)
+	formats := []string{"jpg"}
+	if tg.cfg.ThumbnailFormat == "webp" {
+		formats = append(formats, "webp")
 	}
 
-	// Take screenshot at middle of video
-	middlePoint := duration / 2
+	for _, size := range sizes {
+		for _, format := range formats {
+			if size.Name == "" && format == "jpg" {
+				continue // The source itself
+			}
 
-	// Generate thumbnail using ffmpeg
-	cmd := exec.Command("ffmpeg",
-		"-i", absVideoPath,
-		"-ss", fmt.Sprintf("%.2f", middlePoint), // Take screenshot at middle
-		"-vframes", "1",                         // Extract one frame
-		"-q:v", "2",                             // High quality
-		"-y",                                    // Overwrite output file
-		thumbnailPath,
-	)
+			output := thumbnailFile(tg.cfg, hash, size.Name, format)
+			if !overwrite {
+				if _, err := os.Stat(output); err == nil {
+					continue
+				}
+			}
 
-	if err := cmd.Run(); err != nil {
-		return err
+			args := []string{"-i", source}
+			if size.Width > 0 {
+				args = append(args, "-vf", fmt.Sprintf("scale='min(%d,iw)':-2", size.Width)) // Never upscale
+			}
+			if format == "webp" {
+				args = append(args, "-c:v", "libwebp", "-quality", "80")
+			} else {
+				args = append(args, "-q:v", "3")
+			}
+			args = append(args, "-y", output)
+
+			if err := runFFmpeg(ffmpegCommand(ctx, args...)); err != nil {
+				os.Remove(output)
+				slog.Warn("⚠️  Failed to create thumbnail variant", "hash", hash, "size", size.Name, "format", format, "error", err)
+			}
+		}
 	}
+}
 
-	// Update database with new hash
-	tg.storage.SetThumbnailHash(prefixedPath, videoName, contentHash)
-	return nil
+// thumbnailFile returns the path of a thumbnail for a content hash
+// An empty size (or "large") is the full-size frame, other sizes use a _<size> suffix.
+// Format is the file extension, "jpg" or "webp"
+func thumbnailFile(cfg *config.Config, hash, size, format string) string {
+	if size == "" || size == "large" {
+		return cacheFile(cfg, hash+"."+format)
+	}
+	return cacheFile(cfg, hash+"_"+size+"."+format)
+}
+
+// selectThumbnail returns the best thumbnail file for the requested size
+// Prefers WebP when allowed, then falls back to JPEG and finally the full-size JPEG
+func selectThumbnail(cfg *config.Config, hash, size string, webp bool) string {
+	var candidates []string
+	if webp {
+		candidates = append(candidates, thumbnailFile(cfg, hash, size, "webp"))
+	}
+	candidates = append(candidates, thumbnailFile(cfg, hash, size, "jpg"))
+	if webp {
+		candidates = append(candidates, thumbnailFile(cfg, hash, "", "webp"))
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return thumbnailFile(cfg, hash, "", "jpg")
+}
+
+// acceptsWebP reports whether WebP thumbnails are enabled and the client supports them
+func acceptsWebP(c *gin.Context, cfg *config.Config) bool {
+	return cfg.ThumbnailFormat == "webp" && strings.Contains(c.GetHeader("Accept"), "image/webp")
+}
+
+// isFresh reports whether an existing thumbnail is younger than cfg.ThumbnailMaxAge
+// Stale thumbnails are regenerated even if the content hash is unchanged
+func (tg *ThumbnailGenerator) isFresh(info os.FileInfo) bool {
+	return tg.cfg.ThumbnailMaxAge <= 0 || time.Since(info.ModTime()) < tg.cfg.ThumbnailMaxAge
 }
 
 // GetThumbnail returns or generates a video thumbnail (for API handler)
@@ -256,41 +374,175 @@ func GetThumbnail(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
 			return
 		}
 
+		// Requested size: small, medium, large (full size), etc.
+		size := strings.ToLower(c.Query("size"))
+
+		// An uploaded poster replaces the generated thumbnail in every size
+		if poster, ok := customThumbnail(cfg, store, videoPath); ok {
+			serveCacheFile(c, poster)
+			return
+		}
+
+		// Serve WebP to clients that advertise support, JPEG to everyone else
+		webp := acceptsWebP(c, cfg)
+		c.Header("Vary", "Accept")
+
 		// Check database for existing hash
 		existingHash := store.GetThumbnailHash(videoPath)
 		if existingHash != "" {
-			thumbnailPath := filepath.Join(cfg.ThumbnailDir, existingHash+".jpg")
+			thumbnailPath := thumbnailFile(cfg, existingHash, "", "jpg")
 			if _, err := os.Stat(thumbnailPath); err == nil {
-				c.File(thumbnailPath)
+				serveCacheFile(c, selectThumbnail(cfg, existingHash, size, webp))
 				return
 			}
 		}
 
 		// Calculate file content hash
-		contentHash, err := storage.GetFileContentHash(absVideoPath)
+		contentHash, err := storage.GetFileContentHash(absVideoPath, cfg.HashMode)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate content hash"})
+			requestLog(c).Error("❌ Failed to calculate content hash", "path", videoPath, "error", err)
+			servePlaceholderThumbnail(c, cfg, err)
 			return
 		}
 
-		thumbnailFilename := contentHash + ".jpg"
-		thumbnailPath := filepath.Join(cfg.ThumbnailDir, thumbnailFilename)
+		thumbnailPath := thumbnailFile(cfg, contentHash, "", "jpg")
 
 		// Check if thumbnail exists (same content already generated)
 		if _, err := os.Stat(thumbnailPath); err == nil {
 			// Update database and return
 			store.SetThumbnailHash(videoPath, filepath.Base(absVideoPath), contentHash)
-			c.File(thumbnailPath)
+			serveCacheFile(c, selectThumbnail(cfg, contentHash, size, webp))
 			return
 		}
 
-		// Generate thumbnail on-demand (fallback)
-		tg := NewThumbnailGenerator(cfg, store, 1)
-		if err := tg.generateThumbnail(videoPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate thumbnail"})
+		// Generate thumbnail on-demand (fallback), shared with concurrent requests
+		// for the same content. It isn't tied to this request, the others still wait for it
+		_, err, _ = thumbnailFlights.Do(contentHash, func() (any, error) {
+			tg := NewThumbnailGenerator(cfg, store, 1)
+			return nil, generateOnDemand(context.Background(), cfg, videoPath, func(ctx context.Context) error {
+				return tg.generateThumbnail(ctx, videoPath, contentHash)
+			})
+		})
+		if err != nil {
+			requestLog(c).Error("❌ Failed to generate thumbnail", "path", videoPath, "error", err)
+			servePlaceholderThumbnail(c, cfg, err)
 			return
 		}
 
-		c.File(thumbnailPath)
+		serveCacheFile(c, selectThumbnail(cfg, contentHash, size, webp))
+	}
+}
+
+// maxThumbnailBatch is the most videos one GetThumbnails request may ask for
+const maxThumbnailBatch = 100
+
+// maxInlineThumbnail is the largest thumbnail file GetThumbnails embeds as a data URL
+const maxInlineThumbnail = 64 * 1024
+
+// thumbnailStatus is one video's entry in the GetThumbnails response
+type thumbnailStatus struct {
+	Ready   bool   `json:"ready"`             // A cached thumbnail exists
+	URL     string `json:"url"`               // Loads the thumbnail, generating it if not ready
+	DataURL string `json:"dataUrl,omitempty"` // The thumbnail itself, for ready ones that are small enough
+	Error   string `json:"error,omitempty"`
+}
+
+// GetThumbnails reports the thumbnails of a page of videos in one request,
+// taking the videos as a comma-separated videos parameter and/or repeated video
+// parameters (for paths containing commas). Ready thumbnails of at most
+// maxInlineThumbnail bytes are embedded as data URLs unless inline=false; the
+// others are left to the client to fetch from url. Nothing is generated here,
+// only thumbnails already in the content-hash cache are found.
+func GetThumbnails(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var videos []string
+		for _, list := range c.QueryArray("videos") {
+			videos = append(videos, strings.Split(list, ",")...)
+		}
+		videos = append(videos, c.QueryArray("video")...)
+		if len(videos) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No videos specified"})
+			return
+		}
+		if len(videos) > maxThumbnailBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d videos per request", maxThumbnailBatch)})
+			return
+		}
+
+		size := strings.ToLower(c.DefaultQuery("size", "small"))
+		webp := acceptsWebP(c, cfg)
+		inline := c.Query("inline") != "false"
+		c.Header("Vary", "Accept")
+
+		thumbnails := make(map[string]thumbnailStatus, len(videos))
+		for _, videoPath := range videos {
+			if videoPath == "" {
+				continue
+			}
+			status := thumbnailStatus{URL: "/api/thumbnail?video=" + url.QueryEscape(videoPath) + "&size=" + url.QueryEscape(size)}
+
+			iv := index.Get(videoPath)
+			if iv == nil {
+				status.Error = "Video not found"
+				thumbnails[videoPath] = status
+				continue
+			}
+
+			if poster, ok := customThumbnail(cfg, store, iv.Path); ok {
+				status.Ready = true
+				if inline {
+					status.DataURL = thumbnailDataURL(poster)
+				}
+			} else if hash, ok := cachedThumbnailHash(cfg, store, iv); ok {
+				status.Ready = true
+				if inline {
+					status.DataURL = thumbnailDataURL(selectThumbnail(cfg, hash, size, webp))
+				}
+			}
+			thumbnails[videoPath] = status
+		}
+
+		c.JSON(http.StatusOK, gin.H{"thumbnails": thumbnails})
+	}
+}
+
+// cachedThumbnailHash returns the content hash of a video's cached thumbnail,
+// looked up like GetThumbnail does: the recorded hash first, then the video's
+// current content hash, which is recorded when its thumbnail is found
+// The content hash comes from the index, so a batch never rereads the files;
+// a video the index hasn't hashed is reported as not ready.
+func cachedThumbnailHash(cfg *config.Config, store *storage.Storage, iv *IndexedVideo) (string, bool) {
+	if hash := store.GetThumbnailHash(iv.Path); hash != "" {
+		if _, err := os.Stat(thumbnailFile(cfg, hash, "", "jpg")); err == nil {
+			return hash, true
+		}
+	}
+
+	hash := iv.Hash
+	if hash == "" {
+		return "", false
+	}
+	if _, err := os.Stat(thumbnailFile(cfg, hash, "", "jpg")); err != nil {
+		return "", false
+	}
+	store.SetThumbnailHash(iv.Path, iv.Name, hash)
+	return hash, true
+}
+
+// thumbnailDataURL returns a thumbnail file as a data URL, or "" if it's too
+// large to embed or can't be read
+func thumbnailDataURL(path string) string {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxInlineThumbnail {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	mimeType := "image/jpeg"
+	if strings.HasSuffix(path, ".webp") {
+		mimeType = "image/webp"
 	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
 }
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// fakeFFmpegScript stands in for both binaries: ffprobe reports a 60 second
+// video, ffmpeg writes a few bytes to its output file, always the last argument
+const fakeFFmpegScript = `
+case "$0" in *ffprobe*) echo 60; exit 0;; esac
+for last; do :; done
+printf fake > "$last"
+`
+
+// fakeFFmpeg replaces ffmpeg and ffprobe with fakeFFmpegScript for the test
+func fakeFFmpeg(t *testing.T) {
+	t.Helper()
+	orig := execCommand
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "sh", append([]string{"-c", fakeFFmpegScript, name}, args...)...)
+	}
+	t.Cleanup(func() { execCommand = orig })
+}
+
+// newGeneratorTest returns a config with one video directory holding a video
+// called name, and storage backed by a database of its own
+func newGeneratorTest(t *testing.T, name string) (*config.Config, *storage.Storage) {
+	t.Helper()
+	fakeFFmpeg(t)
+
+	videoDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(videoDir, name), []byte("not really a video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		VideoDirs:              []string{videoDir},
+		ThumbnailDir:           t.TempDir(),
+		HashMode:               "fast",
+		ThumbnailFormat:        "jpg",
+		PreviewSegments:        2,
+		PreviewSegmentDuration: 0.5,
+		PreviewCRF:             28,
+		PreviewPreset:          "fast",
+	}
+
+	db, err := storage.OpenDB(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return cfg, storage.NewStorageWithDB(db)
+}
+
+// precomputedHash is passed to the generators instead of the video's real hash
+const precomputedHash = "0123456789abcdef0123456789abcdef"
+
+func TestGenerateThumbnailUsesGivenHash(t *testing.T) {
+	cfg, store := newGeneratorTest(t, "thumb.mp4")
+	tg := NewThumbnailGenerator(cfg, store, 1)
+
+	if err := tg.generateThumbnail(context.Background(), "0:thumb.mp4", precomputedHash); err != nil {
+		t.Fatalf("generateThumbnail: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.ThumbnailDir, precomputedHash+".jpg")); err != nil {
+		t.Errorf("thumbnail not written under the given hash: %v", err)
+	}
+	realHash, err := storage.GetFileContentHash(filepath.Join(cfg.VideoDirs[0], "thumb.mp4"), cfg.HashMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ThumbnailDir, realHash+".jpg")); err == nil {
+		t.Error("thumbnail written under the file's own hash, the given one was ignored")
+	}
+	if got := store.GetThumbnailHash("0:thumb.mp4"); got != precomputedHash {
+		t.Errorf("stored thumbnail hash = %q, want %q", got, precomputedHash)
+	}
+}
+
+func TestGenerateThumbnailAtPosition(t *testing.T) {
+	cfg, store := newGeneratorTest(t, "reroll.mp4")
+	cfg.ThumbnailPosition = "50%"
+
+	var seeks []string
+	fake := execCommand
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		for i, arg := range args {
+			if arg == "-ss" && i+1 < len(args) {
+				seeks = append(seeks, args[i+1])
+			}
+		}
+		return fake(ctx, name, args...)
+	}
+
+	tg := NewThumbnailGenerator(cfg, store, 1)
+	if err := tg.generateThumbnailAt(context.Background(), "0:reroll.mp4", precomputedHash, "25%"); err != nil {
+		t.Fatalf("generateThumbnailAt: %v", err)
+	}
+	// The fake video is 60 seconds long, the configured 50% would seek to 30
+	if len(seeks) != 1 || seeks[0] != "15.00" {
+		t.Errorf("seeked to %v, want [15.00]", seeks)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Codecs browsers can play natively in a <video> element
+var (
+	webVideoCodecs = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+	webAudioCodecs = map[string]bool{"aac": true, "mp3": true, "opus": true, "vorbis": true}
+)
+
+// transcodeCacheEntry remembers whether a file version needs transcoding
+type transcodeCacheEntry struct {
+	size    int64
+	modTime time.Time
+	needed  bool
+}
+
+// transcodeCache avoids running ffprobe on every (Range) request for the same file
+var transcodeCache sync.Map // absPath -> transcodeCacheEntry
+
+// needsTranscode reports whether a video uses a codec browsers can't play
+// Results are cached per file size and modification time.
+// If ffprobe is unavailable or fails, the file is served as-is
+func needsTranscode(absPath string, info os.FileInfo) bool {
+	if cached, ok := transcodeCache.Load(absPath); ok {
+		entry := cached.(transcodeCacheEntry)
+		if entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+			return entry.needed
+		}
+	}
+
+	needed := false
+	if videoCodec, audioCodec, err := probeCodecs(absPath); err == nil {
+		needed = (videoCodec != "" && !webVideoCodecs[videoCodec]) || (audioCodec != "" && !webAudioCodecs[audioCodec])
+	}
+
+	transcodeCache.Store(absPath, transcodeCacheEntry{size: info.Size(), modTime: info.ModTime(), needed: needed})
+	return needed
+}
+
+// probeCodecs returns the codec names of the first video and audio streams
+func probeCodecs(absPath string) (videoCodec, audioCodec string, err error) {
+	cmd := ffprobeCommand(context.Background(),
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name",
+		"-of", "csv=p=0",
+		absPath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", err
+	}
+
+	// Each line is "codec_name,codec_type"
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), ",")
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[1] {
+		case "video":
+			if videoCodec == "" {
+				videoCodec = parts[0]
+			}
+		case "audio":
+			if audioCodec == "" {
+				audioCodec = parts[0]
+			}
+		}
+	}
+	return videoCodec, audioCodec, nil
+}
+
+// streamTranscoded streams a live H.264/AAC fragmented MP4 transcode of a video
+// Byte ranges can't be served from a live transcode, so seeking uses the "start"
+// query parameter (seconds) instead. ffmpeg is killed when the client disconnects
+func streamTranscoded(c *gin.Context, absPath string) {
+	c.Header("Content-Type", "video/mp4")
+	c.Header("Accept-Ranges", "none")
+	c.Header("Cache-Control", "no-store")
+
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	args := []string{}
+	if start, err := strconv.ParseFloat(c.Query("start"), 64); err == nil && start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.2f", start)) // Input seeking, fast
+	}
+	args = append(args,
+		"-i", absPath,
+		"-map", "0:v:0",
+		"-map", "0:a:0?", // Audio is optional
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p", // 10-bit sources aren't playable in most browsers
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-ac", "2",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"pipe:1",
+	)
+
+	// The request context is cancelled when the client goes away, which kills ffmpeg
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	cmd := ffmpegCommand(ctx, args...)
+	cmd.Stdout = c.Writer
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("❌ Failed to start transcode for %s: %v", absPath, err)
+		c.Writer.Header().Del("Content-Type")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding"})
+		return
+	}
+	if err := cmd.Wait(); err != nil {
+		if clientDisconnected(c, err) {
+			requestLog(c).Debug("Client disconnected during transcode", "path", absPath)
+			return
+		}
+		log.Printf("❌ Transcode failed for %s: %v", absPath, err)
+	}
+}
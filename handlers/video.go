@@ -1,14 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"io/fs"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kitsnail/streamlet/config"
@@ -24,14 +26,30 @@ type Video struct {
 	Path       string `json:"path"`
 	Dir        string `json:"dir,omitempty"` // Source directory index or name
 	Modified   string `json:"modified"`
+	Added      string `json:"added"` // When the video first appeared in the library
 	Views      int    `json:"views"`
 	Likes      int    `json:"likes"`
 	Liked      bool   `json:"liked"`
+	Bookmarked bool   `json:"bookmarked"` // In the user's private "watch later" list
 	Hotness    float64 `json:"hotness"`
+	Position   float64 `json:"position"` // Last playback position in seconds, 0 if none
+	WatchSeconds int   `json:"watchSeconds"` // Total time spent watching
+	Completed  bool    `json:"completed"`    // Watched to the end at least once
+	Tags       []string `json:"tags"`
+	Relevance  float64 `json:"relevance,omitempty"` // Search match score, only set when searching
+	Subtitles  bool    `json:"subtitles"` // A sibling subtitle file is available
+	HasThumbnail bool  `json:"hasThumbnail"` // A thumbnail or uploaded poster exists, requesting it won't wait for generation
+	HasPreview bool    `json:"hasPreview"`    // A hover preview has been generated
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Resolution string  `json:"resolution,omitempty"` // 2160p, 1440p, 1080p, 720p, 480p or sd
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	Bitrate    int64   `json:"bitrate,omitempty"` // Bits per second
 }
 
 // VideoListHandler creates a video list handler with storage
-func VideoListHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+func VideoListHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var videos []Video
 
@@ -39,10 +57,18 @@ func VideoListHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
 		search := c.Query("search")
-		sortBy := c.DefaultQuery("sort", "modified") // modified, views, likes, hotness, name, size, duration
+		fuzzy := c.DefaultQuery("searchMode", "exact") == "fuzzy" // exact, fuzzy
+		sortBy := c.DefaultQuery("sort", "modified") // modified, views, likes, hotness, name, size, duration, watchtime, relevance, bookmarked, added
+		if search != "" && fuzzy && c.Query("sort") == "" {
+			// Best matches first unless another sort was asked for
+			sortBy = "relevance"
+		}
 		order := c.DefaultQuery("order", "desc")     // asc, desc
-		durationMin, _ := strconv.Atoi(c.DefaultQuery("durationMin", "0")) // minutes
-		durationMax, _ := strconv.Atoi(c.DefaultQuery("durationMax", "0")) // minutes, 0 means no limit
+		filter, ok := parseVideoFilter(c, cfg)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown video directory"})
+			return
+		}
 
 		if page < 1 {
 			page = 1
@@ -51,151 +77,72 @@ func VideoListHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 			pageSize = 50
 		}
 
-		// Get all stats
-		allStats := store.GetAllStats()
+		// Get all stats and tags
+		allStats := store.GetAllStats(c.GetString("username"))
+		allTags := store.GetAllVideoTags()
 
-		// Scan all video directories
-		for dirIndex, videoDir := range cfg.VideoDirs {
-			filepath.WalkDir(videoDir, func(path string, d fs.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
+		// Read videos from the cached index
+		for _, iv := range index.Videos() {
+			// Filter by source directory first, it's the cheapest check
+			if !filter.matchIndexed(iv) {
+				continue
+			}
 
-				// Skip macOS hidden files (._*.mp4) and small files
-				if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".mp4") {
-					// Skip macOS AppleDouble files (._filename)
-					if strings.HasPrefix(d.Name(), "._") {
-						return nil
-					}
-
-					info, err := d.Info()
-					if err != nil {
-						return nil
-					}
-
-					// Skip very small files (< 10MB, likely corrupted or placeholder)
-					if info.Size() < 10*1024*1024 {
-						return nil
-					}
-
-					relPath, _ := filepath.Rel(videoDir, path)
-					
-					// Prefix path with directory index to distinguish sources
-					// Format: dirIndex:relPath (e.g., "0:video.mp4", "1:subdir/video.mp4")
-					prefixedPath := fmt.Sprintf("%d:%s", dirIndex, relPath)
-					
-					// Filter by search query
-					if search != "" && !strings.Contains(strings.ToLower(d.Name()), strings.ToLower(search)) {
-						return nil
-					}
-
-					// Get stats
-					stats := allStats[prefixedPath]
-					if stats == nil {
-						stats = &storage.VideoStats{}
-					}
-
-					// Get video duration
-					duration := ""
-					durationSec := 0
-					if dur, err := GetMP4Duration(path); err == nil && dur > 0 {
-						duration = FormatDuration(dur)
-						durationSec = int(dur.Seconds())
-					}
-
-					videos = append(videos, Video{
-						Name:       d.Name(),
-						Size:       info.Size(),
-						Duration:   duration,
-						DurationSec: durationSec,
-						Path:       prefixedPath,
-						Dir:        filepath.Base(videoDir),
-						Modified:   info.ModTime().Format("2006-01-02 15:04"),
-						Views:      stats.Views,
-						Likes:      stats.Likes,
-						Liked:      stats.Liked,
-						Hotness:    stats.Hotness,
-					})
+			// Filter by search query
+			relevance := 0.0
+			if search != "" {
+				score, ok := searchScore(iv.Name, search, fuzzy)
+				if !ok {
+					continue
 				}
-				return nil
-			})
-		}
+				relevance = score
+			}
 
-		// Filter by duration (durationMin and durationMax are in minutes)
-		if durationMin > 0 || durationMax > 0 {
-			filtered := make([]Video, 0)
-			minSec := durationMin * 60
-			maxSec := durationMax * 60
-			
-			for _, v := range videos {
-				// If max is 0, only check min
-				if durationMax == 0 {
-					if v.DurationSec >= minSec {
-						filtered = append(filtered, v)
-					}
-				} else {
-					// Check both min and max
-					if v.DurationSec >= minSec && v.DurationSec < maxSec {
-						filtered = append(filtered, v)
-					}
-				}
+			video := newVideo(cfg, iv, videoStats(allStats, iv.Path), videoTags(allTags, iv.Path))
+			video.Relevance = relevance
+
+			// Filter by tags, resolution, duration and bookmarks
+			if !filter.match(&video) {
+				continue
 			}
-			videos = filtered
+
+			videos = append(videos, video)
 		}
 
-		// Sort based on sortBy parameter
-		isAsc := order == "asc"
-		switch sortBy {
-		case "views":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Views < videos[j].Views
-				}
-				return videos[i].Views > videos[j].Views
-			})
-		case "likes":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Likes < videos[j].Likes
-				}
-				return videos[i].Likes > videos[j].Likes
-			})
-		case "hotness":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Hotness < videos[j].Hotness
-				}
-				return videos[i].Hotness > videos[j].Hotness
-			})
-		case "name":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Name > videos[j].Name
-				}
-				return videos[i].Name < videos[j].Name
-			})
-		case "size":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Size < videos[j].Size
+		// Sort based on sortBy parameter, with a deterministic tie-break
+		compare := videoOrder(cfg, sortBy, order)
+		sort.Slice(videos, func(i, j int) bool { return compare(&videos[i], &videos[j]) < 0 })
+
+		// Cursor pagination, used when a cursor parameter is given (empty for the first page)
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			var after *Video
+			if cursor != "" {
+				var err error
+				if after, err = decodeCursor(cursor, sortBy, order); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor: " + err.Error()})
+					return
 				}
-				return videos[i].Size > videos[j].Size
-			})
-		case "duration":
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].DurationSec < videos[j].DurationSec
-				}
-				return videos[i].DurationSec > videos[j].DurationSec
-			})
-		
-		default: // "modified"
-			sort.Slice(videos, func(i, j int) bool {
-				if isAsc {
-					return videos[i].Modified < videos[j].Modified
-				}
-				return videos[i].Modified > videos[j].Modified
+			}
+			start, end, more := cursorPage(videos, after, compare, pageSize)
+			batch := videos[start:end]
+			setSubtitles(index, batch)
+
+			var nextCursor *string
+			if more {
+				next := encodeCursor(&batch[len(batch)-1], sortBy, order)
+				nextCursor = &next
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"total":      len(videos),
+				"pageSize":   pageSize,
+				"sort":       sortBy,
+				"order":      order,
+				"videos":     batch,
+				"nextCursor": nextCursor, // null on the last page
+				"videoDirs":  cfg.VideoDirs,
+				"minFileSize": cfg.MinFileSize,
 			})
+			return
 		}
 
 		// Pagination
@@ -211,6 +158,8 @@ func VideoListHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 			end = total
 		}
 
+		setSubtitles(index, videos[start:end])
+
 		c.JSON(http.StatusOK, gin.H{
 			"total":      total,
 			"page":       page,
@@ -220,13 +169,151 @@ func VideoListHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 			"order":      order,
 			"videos":     videos[start:end],
 			"videoDirs":  cfg.VideoDirs,
+			"minFileSize": cfg.MinFileSize, // Files smaller than this are hidden, 0 means no filter
 		})
 	}
 }
 
+// setSubtitles looks up subtitle files, only for the videos actually returned
+func setSubtitles(index *VideoIndex, videos []Video) {
+	for i := range videos {
+		if iv := index.Get(videos[i].Path); iv != nil {
+			_, videos[i].Subtitles = findSubtitle(iv.AbsPath)
+		}
+	}
+}
+
+// newVideo builds the API representation of an indexed video
+func newVideo(cfg *config.Config, iv *IndexedVideo, stats *storage.VideoStats, tags []string) Video {
+	duration := ""
+	durationSec := 0
+	if iv.Duration > 0 {
+		duration = FormatDuration(iv.Duration)
+		durationSec = int(iv.Duration.Seconds())
+	}
+
+	video := Video{
+		Name:       iv.Name,
+		Size:       iv.Size,
+		Duration:   duration,
+		DurationSec: durationSec,
+		Path:       iv.Path,
+		Dir:        filepath.Base(cfg.VideoDirs[iv.DirIndex]),
+		Modified:   iv.ModTime.Format("2006-01-02 15:04"),
+		Added:      iv.ModTime.Format("2006-01-02 15:04"),
+		Views:      stats.Views,
+		Likes:      stats.Likes,
+		Liked:      stats.Liked,
+		Bookmarked: stats.Bookmarked,
+		Hotness:    stats.Hotness,
+		Position:   stats.PositionSec,
+		WatchSeconds: int(stats.WatchSeconds),
+		Completed:  stats.Completed,
+		Tags:       tags,
+		HasThumbnail: stats.ThumbnailHash != "" || stats.CustomThumbnail != "",
+		HasPreview: stats.PreviewHash != "",
+	}
+	if !stats.FirstSeen.IsZero() {
+		video.Added = stats.FirstSeen.Local().Format("2006-01-02 15:04")
+	}
+	if m := iv.Metadata; m != nil {
+		video.Width = m.Width
+		video.Height = m.Height
+		video.Resolution = resolutionLabel(m.Width, m.Height)
+		video.VideoCodec = m.VideoCodec
+		video.AudioCodec = m.AudioCodec
+		video.Bitrate = m.Bitrate
+	}
+	return video
+}
+
+// videoStats returns the stats of a video from GetAllStats, empty if it has none
+func videoStats(allStats map[string]*storage.VideoStats, path string) *storage.VideoStats {
+	if stats := allStats[path]; stats != nil {
+		return stats
+	}
+	return &storage.VideoStats{}
+}
+
+// videoTags returns the tags of a video from GetAllVideoTags, never nil
+func videoTags(allTags map[string][]string, path string) []string {
+	if tags := allTags[path]; tags != nil {
+		return tags
+	}
+	return []string{}
+}
+
+// parseDirFilter resolves a dir query parameter to an index into cfg.VideoDirs
+// It accepts the index or the directory's name (or full path); empty means all (-1).
+// ok is false when nothing matches
+func parseDirFilter(cfg *config.Config, value string) (int, bool) {
+	if value == "" {
+		return -1, true
+	}
+	if i, err := strconv.Atoi(value); err == nil {
+		return i, i >= 0 && i < len(cfg.VideoDirs)
+	}
+	for i, dir := range cfg.VideoDirs {
+		if value == dir || value == filepath.Base(dir) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// parseTagList parses a comma-separated tag filter
+func parseTagList(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = storage.NormalizeTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchTags reports whether videoTags has all of the wanted tags, or any of them when any is set
+func matchTags(videoTags, wanted []string, any bool) bool {
+	have := make(map[string]bool, len(videoTags))
+	for _, tag := range videoTags {
+		have[tag] = true
+	}
+	for _, tag := range wanted {
+		if have[tag] && any {
+			return true
+		}
+		if !have[tag] && !any {
+			return false
+		}
+	}
+	return !any
+}
+
+// videoContentType returns the MIME type for a video file based on its extension
+func videoContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mkv":
+		return "video/x-matroska"
+	case ".webm":
+		return "video/webm"
+	case ".mov":
+		return "video/quicktime"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".m4v":
+		return "video/x-m4v"
+	default:
+		return "video/mp4"
+	}
+}
+
 // parseVideoPath parses prefixed video path (format: dirIndex:relPath)
 // Returns the absolute path to the video file
 func parseVideoPath(prefixedPath string, cfg *config.Config) (string, error) {
+	if err := validateVideoPath(prefixedPath, cfg); err != nil {
+		return "", err
+	}
+
 	// Check if path has prefix
 	if strings.Contains(prefixedPath, ":") {
 		parts := strings.SplitN(prefixedPath, ":", 2)
@@ -250,6 +337,31 @@ func parseVideoPath(prefixedPath string, cfg *config.Config) (string, error) {
 	return filepath.Join(cfg.VideoDir, prefixedPath), nil
 }
 
+// isInVideoDirs reports whether an absolute path is within one of the video directories
+func isInVideoDirs(cfg *config.Config, absPath string) bool {
+	for _, videoDir := range cfg.VideoDirs {
+		absVideoDir, _ := filepath.Abs(videoDir)
+		if strings.HasPrefix(absPath, absVideoDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// videoFileExists reports whether a prefixed path resolves to a video file inside the video directories
+func videoFileExists(cfg *config.Config, prefixedPath string) bool {
+	absPath, err := parseVideoPath(prefixedPath, cfg)
+	if err != nil {
+		return false
+	}
+	absPath, err = filepath.Abs(absPath)
+	if err != nil || !isInVideoDirs(cfg, absPath) {
+		return false
+	}
+	info, err := os.Stat(absPath)
+	return err == nil && info.Mode().IsRegular()
+}
+
 // VideoViewHandler increments view count
 func VideoViewHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -263,8 +375,9 @@ func VideoViewHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 			return
 		}
 
-		store.IncrementViews(req.Path, req.Name)
-		stats := store.GetStats(req.Path)
+		username := c.GetString("username")
+		store.IncrementViews(req.Path, req.Name, username)
+		stats := store.GetStats(req.Path, username)
 
 		c.JSON(http.StatusOK, gin.H{
 			"views":   stats.Views,
@@ -286,8 +399,9 @@ func VideoLikeHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 			return
 		}
 
-		liked := store.ToggleLike(req.Path, req.Name)
-		stats := store.GetStats(req.Path)
+		username := c.GetString("username")
+		liked := store.ToggleLike(req.Path, req.Name, username)
+		stats := store.GetStats(req.Path, username)
 
 		c.JSON(http.StatusOK, gin.H{
 			"liked":   liked,
@@ -297,8 +411,114 @@ func VideoLikeHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFun
 	}
 }
 
+// VideoBookmarkHandler toggles the user's bookmark on a video
+func VideoBookmarkHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Path string `json:"path"`
+			Name string `json:"name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		bookmarked := store.ToggleBookmark(req.Path, req.Name, c.GetString("username"))
+		c.JSON(http.StatusOK, gin.H{"bookmarked": bookmarked})
+	}
+}
+
+// RecomputeHotnessHandler recalculates hotness for all videos with the current weights (admin only)
+func RecomputeHotnessHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		updated := store.RecomputeHotness()
+		c.JSON(http.StatusOK, gin.H{
+			"updated": updated,
+		})
+	}
+}
+
+// HotnessHandler returns the components of a video's hotness score
+func HotnessHandler(cfg *config.Config, store *storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		videoPath := c.Query("video")
+		if videoPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No video specified"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"path":      videoPath,
+			"breakdown": store.HotnessBreakdown(videoPath),
+			"stored":    store.GetStats(videoPath, "").Hotness, // May lag behind until the next view/like or recompute
+			"weights": gin.H{
+				"viewWeight":      cfg.Hotness.ViewWeight,
+				"likeWeight":      cfg.Hotness.LikeWeight,
+				"recencyBonus":    cfg.Hotness.RecencyBonus,
+				"halfLifeSeconds": cfg.Hotness.HalfLife.Seconds(),
+				"byWatchTime":     cfg.Hotness.ByWatchTime,
+			},
+		})
+	}
+}
+
+// resumeEndMargin is how close to the end (seconds) a saved position counts as finished
+const resumeEndMargin = 5.0
+
+// PositionHandler records the current playback position for resume and accumulates watch time
+// Positions within resumeEndMargin of the end mark the video completed and are stored as 0
+// so the next play starts over
+func PositionHandler(cfg *config.Config, store *storage.Storage, index *VideoIndex) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Path     string  `json:"path"`
+			Name     string  `json:"name"`
+			Position float64 `json:"position"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" || req.Position < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		// Playback moving forward by a plausible amount since the last report counts as watch time,
+		// seeks and long gaps don't
+		username := c.GetString("username")
+		watched := req.Position - store.GetPosition(req.Path, username)
+		if watched < 0 || watched > maxHeartbeatGap.Seconds() {
+			watched = 0
+		}
+
+		position := req.Position
+		completed := false
+		if iv := index.Get(req.Path); iv != nil && iv.Duration > 0 {
+			if position >= iv.Duration.Seconds()-resumeEndMargin {
+				position = 0
+				completed = true
+			}
+		}
+
+		store.SetPosition(req.Path, req.Name, username, position)
+		if watched > 0 || completed {
+			store.AddWatchTime(req.Path, req.Name, username, watched, completed)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"position":  position,
+			"completed": completed,
+		})
+	}
+}
+
 // StreamVideo streams video file with Range support
-func StreamVideo(cfg *config.Config) gin.HandlerFunc {
+// Videos with codecs browsers can't play (or with ?transcode=1) are transcoded on the fly;
+// ?transcode=0 always serves the original file
+func StreamVideo(cfg *config.Config, streamStats *StreamStats) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get filename from path
 		filename := c.Param("filename")
@@ -319,16 +539,7 @@ func StreamVideo(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		// Check if path is within any allowed directory
-		allowed := false
-		for _, videoDir := range cfg.VideoDirs {
-			absVideoDir, _ := filepath.Abs(videoDir)
-			if strings.HasPrefix(absPath, absVideoDir) {
-				allowed = true
-				break
-			}
-		}
-
-		if !allowed {
+		if !isInVideoDirs(cfg, absPath) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 			return
 		}
@@ -348,15 +559,69 @@ func StreamVideo(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// Use http.ServeContent to handle Range requests properly
-		// This is the standard way to serve static files with Range support
-		c.Header("Content-Type", "video/mp4")
-		c.Header("Accept-Ranges", "bytes")
-		c.Header("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-		http.ServeContent(c.Writer, c.Request, "video.mp4", stat.ModTime(), file)
+		started := time.Now()
+		if c.Query("transcode") == "1" || (c.Query("transcode") != "0" && needsTranscode(absPath, stat)) {
+			// Codec browsers can't play, stream a live transcode instead
+			streamTranscoded(c, absPath)
+		} else {
+			// Use http.ServeContent to handle Range requests properly
+			// This is the standard way to serve static files with Range support
+			c.Header("Content-Type", videoContentType(absPath))
+			c.Header("Accept-Ranges", "bytes")
+			// Size and modification time change when a file is replaced in place,
+			// so clients revalidate after max-age instead of keeping stale content
+			c.Header("ETag", fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano()))
+			c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d, must-revalidate", int(cfg.StreamCacheMaxAge.Seconds())))
+
+			// Reads stop once the client goes away instead of finishing the range
+			http.ServeContent(c.Writer, c.Request, filepath.Base(absPath), stat.ModTime(), contextReader{c.Request.Context(), file})
+			if clientDisconnected(c, nil) {
+				requestLog(c).Debug("Client disconnected during stream", "path", filename, "bytes", c.Writer.Size())
+			}
+		}
+
+		// HEAD probes (e.g. download managers checking size) aren't plays
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+
+		// Record bytes actually written, so partial (Range) plays are measured
+		bytesServed := int64(c.Writer.Size())
+		if bytesServed < 0 {
+			bytesServed = 0
+		}
+		streamStats.Record(StreamRecord{
+			Path:       filename,
+			Username:   c.GetString("username"),
+			Range:      c.GetHeader("Range"),
+			Status:     c.Writer.Status(),
+			Bytes:      bytesServed,
+			Started:    started,
+			DurationMs: time.Since(started).Milliseconds(),
+		})
 	}
 }
 
+// contextReader stops reading once ctx is cancelled, so an abandoned stream
+// doesn't keep reading the file from disk
+type contextReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+// clientDisconnected reports whether the client of a request went away, either
+// seen through the request context or as a write error
+func clientDisconnected(c *gin.Context, err error) bool {
+	return c.Request.Context().Err() != nil || isBrokenPipe(err)
+}
+
 // isBrokenPipe checks if error is a broken pipe or connection reset
 func isBrokenPipe(err error) bool {
 	if err == nil {
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// maxHeartbeatGap caps how much watch time a single heartbeat can add,
+// so a paused or backgrounded tab doesn't count as watching
+const maxHeartbeatGap = 60 * time.Second
+
+// watchSession tracks one playback of a video
+type watchSession struct {
+	Path          string
+	Name          string
+	Username      string
+	Position      float64       // Last reported playback position in seconds
+	Watched       time.Duration // Accumulated watch time
+	Counted       bool          // Whether the view has been counted
+	LastHeartbeat time.Time
+}
+
+// WatchSessions holds active watch sessions in memory
+type WatchSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*watchSession
+}
+
+// NewWatchSessions creates an empty session store
+func NewWatchSessions() *WatchSessions {
+	return &WatchSessions{sessions: make(map[string]*watchSession)}
+}
+
+// start creates a new session and drops expired ones
+func (ws *WatchSessions) start(path, name, username string, ttl time.Duration) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range ws.sessions {
+		if now.Sub(s.LastHeartbeat) > ttl {
+			delete(ws.sessions, id)
+		}
+	}
+
+	id := newSessionID()
+	ws.sessions[id] = &watchSession{
+		Path:          path,
+		Name:          name,
+		Username:      username,
+		LastHeartbeat: now,
+	}
+	return id
+}
+
+// newSessionID returns a random hex session identifier
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WatchStartHandler starts a watch session for a video
+func WatchStartHandler(cfg *config.Config, sessions *WatchSessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Path string `json:"path"`
+			Name string `json:"name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		id := sessions.start(req.Path, req.Name, c.GetString("username"), cfg.WatchSessionTTL)
+		c.JSON(http.StatusOK, gin.H{
+			"sessionId":        id,
+			"thresholdSeconds": cfg.WatchThreshold.Seconds(),
+		})
+	}
+}
+
+// WatchHeartbeatHandler extends a watch session with the current position
+// The view is counted once, after the session reaches cfg.WatchThreshold
+func WatchHeartbeatHandler(cfg *config.Config, store *storage.Storage, sessions *WatchSessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			SessionID string  `json:"sessionId"`
+			Position  float64 `json:"position"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		sessions.mu.Lock()
+		session, ok := sessions.sessions[req.SessionID]
+		if !ok || session.Username != c.GetString("username") {
+			sessions.mu.Unlock()
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		now := time.Now()
+		elapsed := now.Sub(session.LastHeartbeat)
+		if elapsed > maxHeartbeatGap {
+			elapsed = maxHeartbeatGap
+		}
+		session.Watched += elapsed
+		session.Position = req.Position
+		session.LastHeartbeat = now
+
+		countView := !session.Counted && session.Watched >= cfg.WatchThreshold
+		if countView {
+			session.Counted = true
+		}
+		path, name, username, watched, counted := session.Path, session.Name, session.Username, session.Watched, session.Counted
+		sessions.mu.Unlock()
+
+		if countView {
+			store.IncrementViews(path, name, username)
+		}
+
+		stats := store.GetStats(path, username)
+		c.JSON(http.StatusOK, gin.H{
+			"counted":        counted,
+			"watchedSeconds": int(watched.Seconds()),
+			"views":          stats.Views,
+			"hotness":        stats.Hotness,
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kitsnail/streamlet/config"
+	"github.com/kitsnail/streamlet/storage"
+)
+
+// pendingFile tracks a file that is still being written
+type pendingFile struct {
+	size  int64
+	since time.Time // When the size was last seen to change
+}
+
+// DirWatcher keeps the video index up to date from filesystem events
+// New files are only indexed once their size has been stable for
+// cfg.WatchStableDuration, so large copies in progress aren't picked up early
+type DirWatcher struct {
+	cfg     *config.Config
+	index   *VideoIndex
+	store   *storage.Storage
+	watcher *fsnotify.Watcher
+	pending map[string]*pendingFile
+}
+
+// NewDirWatcher creates a watcher over all video directories
+func NewDirWatcher(cfg *config.Config, index *VideoIndex, store *storage.Storage) (*DirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &DirWatcher{
+		cfg:     cfg,
+		index:   index,
+		store:   store,
+		watcher: watcher,
+		pending: make(map[string]*pendingFile),
+	}
+	for _, videoDir := range cfg.VideoDirs {
+		dw.addRecursive(videoDir, false)
+	}
+	return dw, nil
+}
+
+// Run processes filesystem events until the watcher is closed
+func (dw *DirWatcher) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-dw.watcher.Events:
+			if !ok {
+				return
+			}
+			dw.handleEvent(event)
+		case err, ok := <-dw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("⚠️  Watcher error: %v", err)
+		case <-ticker.C:
+			dw.flushPending()
+		}
+	}
+}
+
+// Close stops the watcher
+func (dw *DirWatcher) Close() error {
+	return dw.watcher.Close()
+}
+
+// handleEvent queues created/modified files and removes deleted ones
+func (dw *DirWatcher) handleEvent(event fsnotify.Event) {
+	path := event.Name
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// A rename shows up as Rename on the old name and Create on the new one
+		delete(dw.pending, path)
+		for _, removed := range dw.index.Remove(path) {
+			log.Printf("📇 Removed from index: %s", removed)
+			if dw.cfg.WatchPruneStats {
+				dw.store.DeleteStats(removed)
+			}
+		}
+		return
+	}
+
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if info.IsDir() {
+		// Watch new subdirectories and pick up any files moved in with them
+		dw.addRecursive(path, true)
+		return
+	}
+
+	dw.queue(path, info)
+}
+
+// addRecursive watches dir and all of its subdirectories
+// When queueFiles is set, video files found along the way are queued for indexing
+func (dw *DirWatcher) addRecursive(dir string, queueFiles bool) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if isInThumbnailDir(dw.cfg, path) {
+				return filepath.SkipDir
+			}
+			if err := dw.watcher.Add(path); err != nil {
+				log.Printf("⚠️  Failed to watch %s: %v", path, err)
+			}
+			return nil
+		}
+		if queueFiles && isVideoFile(d.Name(), dw.cfg) {
+			if info, err := d.Info(); err == nil {
+				dw.queue(path, info)
+			}
+		}
+		return nil
+	})
+}
+
+// queue records a file as pending until its size stops changing
+func (dw *DirWatcher) queue(path string, info os.FileInfo) {
+	if !isVideoFile(info.Name(), dw.cfg) {
+		return
+	}
+	if p, ok := dw.pending[path]; ok {
+		if p.size != info.Size() {
+			p.size = info.Size()
+			p.since = time.Now()
+		}
+		return
+	}
+	dw.pending[path] = &pendingFile{size: info.Size(), since: time.Now()}
+}
+
+// flushPending indexes pending files whose size has been stable long enough
+func (dw *DirWatcher) flushPending() {
+	now := time.Now()
+	for path, p := range dw.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(dw.pending, path)
+			continue
+		}
+		if info.Size() != p.size {
+			p.size = info.Size()
+			p.since = now
+			continue
+		}
+		if now.Sub(p.since) < dw.cfg.WatchStableDuration {
+			continue
+		}
+
+		delete(dw.pending, path)
+		if dw.index.Upsert(path) {
+			log.Printf("📇 Indexed new video: %s", path)
+			// The new file may be a renamed or moved one
+			if relinked := dw.index.Reconcile(); relinked > 0 {
+				log.Printf("📇 Relinked stats of %d moved videos", relinked)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword implements `streamlet hash-password [password]`
+// It prints a bcrypt hash for use in AUTH_PASS_HASH. Without an argument the password
+// is read from stdin, which keeps it out of the shell history
+func hashPassword(args []string) int {
+	var password string
+	if len(args) > 0 {
+		password = args[0]
+	} else {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "❌ Failed to read password:", err)
+			return 1
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "❌ Password must not be empty")
+		return 1
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Failed to hash password:", err)
+		return 1
+	}
+
+	fmt.Println(string(hash))
+	return 0
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kitsnail/streamlet/config"
@@ -13,31 +18,48 @@ import (
 	"github.com/kitsnail/streamlet/storage"
 )
 
-var (
-	previewRunning   bool
-	thumbnailRunning bool
-	genMutex         sync.Mutex
-	previewProgress  struct {
-		Total   int
-		Done    int
-		Failed  int
-		Running bool
-	}
-	thumbnailProgress struct {
-		Total   int
-		Done    int
-		Failed  int
-		Running bool
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		os.Exit(hashPassword(os.Args[2:]))
 	}
-)
 
-func main() {
 	// Load config
 	cfg := config.Load()
+	handlers.SetupLogging(cfg)
+	handlers.SetJWTSecret(cfg)
+
+	// Every thumbnail, preview and probe needs ffmpeg, so report a missing install once up front
+	ffmpegVersions, ffmpegErr := handlers.CheckFFmpeg(cfg)
+	if ffmpegErr != nil {
+		log.Printf("❌ %v", ffmpegErr)
+	} else {
+		log.Printf("🎞️  Using %s", ffmpegVersions.FFmpeg)
+		log.Printf("🎞️  Using %s", ffmpegVersions.FFprobe)
+	}
+
+	// Cancelled on Ctrl+C or SIGTERM, which stops generation and shuts the server down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Tracks running generation so shutdown can wait for in-flight jobs
+	var generation sync.WaitGroup
 
 	// Initialize storage
-	videoStore := storage.NewStorage(cfg.DataDir)
-	playlistStore := storage.NewPlaylistStorage(cfg.DataDir)
+	db, err := storage.OpenDB(cfg.DataDir, cfg.DBMaxOpenConns)
+	if err != nil {
+		log.Fatalf("❌ Failed to open database: %v", err)
+	}
+	videoStore := storage.NewStorageWithDB(db)
+	videoStore.SetHotnessConfig(cfg.Hotness)
+	// Stats recorded before accounts existed belong to the env-var user
+	videoStore.ClaimLegacyStats(cfg.Username)
+	playlistStore := storage.NewPlaylistStorageWithDB(db)
+	videoIndex := handlers.NewVideoIndex(cfg, videoStore)
+	playlistStore.SetDurationLookup(videoIndex.Duration)
+	handlers.EnableShareLinks(playlistStore)
+	streamStats := handlers.NewStreamStats()
+	watchSessions := handlers.NewWatchSessions()
+	generationManager := handlers.NewGenerationManager(ctx, cfg, videoStore, &generation)
 
 	// Set gin mode
 	if cfg.Env == "production" {
@@ -45,7 +67,8 @@ func main() {
 	}
 
 	// Create router
-	r := gin.Default()
+	r := gin.New()
+	r.Use(handlers.RequestLogger(), gin.Recovery(), handlers.CORS(cfg))
 
 	// Load HTML templates
 	r.LoadHTMLGlob("static/*.html")
@@ -58,98 +81,69 @@ func main() {
 		c.Redirect(302, "/login")
 	})
 	r.GET("/login", handlers.LoginPage)
-	r.POST("/api/login", handlers.Login(cfg))
+	r.POST("/api/login", handlers.Login(cfg, videoStore))
+	r.POST("/api/refresh", handlers.RefreshHandler(cfg))
+	r.POST("/api/logout", handlers.Logout(cfg))
+	r.GET("/share/:token", handlers.SharePage(cfg, playlistStore))
 	
 	// Protected routes - Videos
-	r.GET("/api/videos", handlers.AuthMiddleware(cfg), handlers.VideoListHandler(cfg, videoStore))
-	r.GET("/api/video/*filename", handlers.AuthMiddleware(cfg), handlers.StreamVideo(cfg))
+	r.GET("/api/config", handlers.AuthMiddleware(cfg), handlers.ConfigHandler(cfg))
+	r.GET("/api/videos", handlers.AuthMiddleware(cfg), handlers.VideoListHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/browse", handlers.AuthMiddleware(cfg), handlers.BrowseHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/random", handlers.AuthMiddleware(cfg), handlers.RandomVideoHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/related", handlers.AuthMiddleware(cfg), handlers.RelatedVideosHandler(cfg, videoStore, videoIndex))
+	r.POST("/api/rescan", handlers.AuthMiddleware(cfg), handlers.RescanHandler(cfg, videoIndex))
+	r.POST("/api/reconcile", handlers.AuthMiddleware(cfg), handlers.ReconcileHandler(cfg, videoIndex))
+	r.GET("/api/video/*filename", handlers.AuthMiddleware(cfg), handlers.StreamVideo(cfg, streamStats))
+	r.HEAD("/api/video/*filename", handlers.AuthMiddleware(cfg), handlers.StreamVideo(cfg, streamStats))
+	r.GET("/api/subtitles/*filename", handlers.AuthMiddleware(cfg), handlers.GetSubtitles(cfg))
+	r.GET("/api/stream/stats", handlers.AuthMiddleware(cfg), handlers.StreamStatsHandler(cfg, streamStats))
 	r.GET("/api/thumbnail", handlers.AuthMiddleware(cfg), handlers.GetThumbnail(cfg, videoStore))
+	r.HEAD("/api/thumbnail", handlers.AuthMiddleware(cfg), handlers.GetThumbnail(cfg, videoStore))
+	r.GET("/api/thumbnails", handlers.AuthMiddleware(cfg), handlers.GetThumbnails(cfg, videoStore, videoIndex))
 	r.GET("/api/preview", handlers.AuthMiddleware(cfg), handlers.GetPreview(cfg, videoStore))
+	r.GET("/api/storyboard", handlers.AuthMiddleware(cfg), handlers.GetStoryboard(cfg, videoIndex))
+	r.GET("/api/chapters", handlers.AuthMiddleware(cfg), handlers.GetChapters(cfg, videoIndex))
+	r.HEAD("/api/preview", handlers.AuthMiddleware(cfg), handlers.GetPreview(cfg, videoStore))
 	r.POST("/api/view", handlers.AuthMiddleware(cfg), handlers.VideoViewHandler(cfg, videoStore))
 	r.POST("/api/like", handlers.AuthMiddleware(cfg), handlers.VideoLikeHandler(cfg, videoStore))
+	r.POST("/api/bookmark", handlers.AuthMiddleware(cfg), handlers.VideoBookmarkHandler(cfg, videoStore))
+	r.GET("/api/hotness", handlers.AuthMiddleware(cfg), handlers.HotnessHandler(cfg, videoStore))
+	r.POST("/api/recompute-hotness", handlers.AuthMiddleware(cfg), handlers.RecomputeHotnessHandler(cfg, videoStore))
+	r.POST("/api/position", handlers.AuthMiddleware(cfg), handlers.PositionHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/history", handlers.AuthMiddleware(cfg), handlers.HistoryHandler(cfg, videoStore))
+	r.DELETE("/api/history", handlers.AuthMiddleware(cfg), handlers.ClearHistoryHandler(cfg, videoStore))
+	r.GET("/api/continue", handlers.AuthMiddleware(cfg), handlers.ContinueWatchingHandler(cfg, videoStore, videoIndex))
+	r.DELETE("/api/continue", handlers.AuthMiddleware(cfg), handlers.DismissContinueHandler(cfg, videoStore))
+	r.GET("/api/users", handlers.AuthMiddleware(cfg), handlers.ListUsersHandler(cfg, videoStore))
+	r.POST("/api/users", handlers.AuthMiddleware(cfg), handlers.CreateUserHandler(cfg, videoStore))
+	r.GET("/api/backup", handlers.AuthMiddleware(cfg), handlers.BackupHandler(cfg, videoStore))
+	r.POST("/api/restore", handlers.AuthMiddleware(cfg), handlers.RestoreHandler(cfg, videoStore))
+	r.GET("/api/export", handlers.AuthMiddleware(cfg), handlers.ExportHandler(cfg, videoStore, videoIndex))
+	r.POST("/api/import", handlers.AuthMiddleware(cfg), handlers.ImportHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/tags", handlers.AuthMiddleware(cfg), handlers.TagsHandler(cfg, videoStore))
+	r.POST("/api/tags", handlers.AuthMiddleware(cfg), handlers.AddTagHandler(cfg, videoStore))
+	r.DELETE("/api/tags", handlers.AuthMiddleware(cfg), handlers.RemoveTagHandler(cfg, videoStore))
+	r.POST("/api/watch/start", handlers.AuthMiddleware(cfg), handlers.WatchStartHandler(cfg, watchSessions))
+	r.POST("/api/watch/heartbeat", handlers.AuthMiddleware(cfg), handlers.WatchHeartbeatHandler(cfg, videoStore, watchSessions))
 
 	// Protected routes - Media generation
-	r.POST("/api/previews/generate", handlers.AuthMiddleware(cfg), func(c *gin.Context) {
-		genMutex.Lock()
-		defer genMutex.Unlock()
-
-		if previewRunning {
-			c.JSON(http.StatusConflict, gin.H{
-				"error":    "Preview generation already running",
-				"progress": previewProgress,
-			})
-			return
-		}
-
-		previewRunning = true
-		previewProgress.Running = true
-
-		go func() {
-			generator := handlers.NewPreviewGenerator(cfg, videoStore, 4)
-			generator.SetProgressCallback(func(total, done, failed int) {
-				genMutex.Lock()
-				previewProgress.Total = total
-				previewProgress.Done = done
-				previewProgress.Failed = failed
-				genMutex.Unlock()
-			})
-			generator.GenerateAll()
-
-			genMutex.Lock()
-			previewRunning = false
-			previewProgress.Running = false
-			genMutex.Unlock()
-		}()
-
-		c.JSON(http.StatusOK, gin.H{"message": "Preview generation started"})
-	})
-
-	r.GET("/api/previews/status", handlers.AuthMiddleware(cfg), func(c *gin.Context) {
-		genMutex.Lock()
-		defer genMutex.Unlock()
-		c.JSON(http.StatusOK, previewProgress)
-	})
-
-	r.POST("/api/thumbnails/generate", handlers.AuthMiddleware(cfg), func(c *gin.Context) {
-		genMutex.Lock()
-		defer genMutex.Unlock()
-
-		if thumbnailRunning {
-			c.JSON(http.StatusConflict, gin.H{
-				"error":    "Thumbnail generation already running",
-				"progress": thumbnailProgress,
-			})
-			return
-		}
-
-		thumbnailRunning = true
-		thumbnailProgress.Running = true
-
-		go func() {
-			generator := handlers.NewThumbnailGenerator(cfg, videoStore, 4)
-			generator.SetProgressCallback(func(total, done, failed int) {
-				genMutex.Lock()
-				thumbnailProgress.Total = total
-				thumbnailProgress.Done = done
-				thumbnailProgress.Failed = failed
-				genMutex.Unlock()
-			})
-			generator.GenerateAll()
-
-			genMutex.Lock()
-			thumbnailRunning = false
-			thumbnailProgress.Running = false
-			genMutex.Unlock()
-		}()
-
-		c.JSON(http.StatusOK, gin.H{"message": "Thumbnail generation started"})
-	})
-
-	r.GET("/api/thumbnails/status", handlers.AuthMiddleware(cfg), func(c *gin.Context) {
-		genMutex.Lock()
-		defer genMutex.Unlock()
-		c.JSON(http.StatusOK, thumbnailProgress)
-	})
+	r.POST("/api/previews/generate", handlers.AuthMiddleware(cfg), handlers.GeneratePreviewsHandler(cfg, generationManager))
+	r.GET("/api/previews/status", handlers.AuthMiddleware(cfg), handlers.PreviewStatusHandler(cfg, generationManager))
+	r.POST("/api/previews/cancel", handlers.AuthMiddleware(cfg), handlers.CancelPreviewsHandler(cfg, generationManager))
+	r.GET("/api/previews/events", handlers.AuthMiddleware(cfg), handlers.ProgressEventsHandler(cfg, generationManager.PreviewEvents()))
+	r.POST("/api/thumbnails/generate", handlers.AuthMiddleware(cfg), handlers.GenerateThumbnailsHandler(cfg, generationManager))
+	r.GET("/api/thumbnails/status", handlers.AuthMiddleware(cfg), handlers.ThumbnailStatusHandler(cfg, generationManager))
+	r.POST("/api/thumbnails/cancel", handlers.AuthMiddleware(cfg), handlers.CancelThumbnailsHandler(cfg, generationManager))
+	r.GET("/api/generation/status", handlers.AuthMiddleware(cfg), handlers.GenerationStatusHandler(cfg, generationManager))
+	r.GET("/api/generation/missing", handlers.AuthMiddleware(cfg), handlers.MissingGenerationHandler(cfg, videoStore, videoIndex))
+	r.GET("/api/corrupt", handlers.AuthMiddleware(cfg), handlers.CorruptVideosHandler(cfg, videoStore, videoIndex))
+	r.POST("/api/thumbnail/regenerate", handlers.AuthMiddleware(cfg), handlers.RegenerateThumbnailHandler(cfg, videoStore))
+	r.POST("/api/thumbnail/upload", handlers.AuthMiddleware(cfg), handlers.UploadThumbnailHandler(cfg, videoStore))
+	r.DELETE("/api/thumbnail/upload", handlers.AuthMiddleware(cfg), handlers.DeleteThumbnailHandler(cfg, videoStore))
+	r.POST("/api/preview/regenerate", handlers.AuthMiddleware(cfg), handlers.RegeneratePreviewHandler(cfg, videoStore))
+	r.GET("/api/stats/summary", handlers.AuthMiddleware(cfg), handlers.StatsSummaryHandler(cfg, videoStore, videoIndex))
+	r.POST("/api/cleanup", handlers.AuthMiddleware(cfg), handlers.CleanupHandler(cfg, videoStore))
 	
 	// Protected routes - Playlists
 	r.GET("/api/playlists", handlers.AuthMiddleware(cfg), handlers.PlaylistHandler(cfg, playlistStore))
@@ -158,7 +152,13 @@ func main() {
 	r.PUT("/api/playlists/:id", handlers.AuthMiddleware(cfg), handlers.UpdatePlaylistHandler(cfg, playlistStore))
 	r.DELETE("/api/playlists/:id", handlers.AuthMiddleware(cfg), handlers.DeletePlaylistHandler(cfg, playlistStore))
 	r.POST("/api/playlists/add", handlers.AuthMiddleware(cfg), handlers.AddToPlaylistHandler(cfg, playlistStore))
+	r.POST("/api/playlists/:id/videos/bulk", handlers.AuthMiddleware(cfg), handlers.BulkPlaylistVideosHandler(cfg, playlistStore))
 	r.DELETE("/api/playlists/:id/video", handlers.AuthMiddleware(cfg), handlers.RemoveFromPlaylistHandler(cfg, playlistStore))
+	r.GET("/api/playlists/:id/validate", handlers.AuthMiddleware(cfg), handlers.ValidatePlaylistHandler(cfg, playlistStore))
+	r.GET("/api/playlists/:id/cover", handlers.AuthMiddleware(cfg), handlers.PlaylistCoverHandler(cfg, videoStore, playlistStore))
+	r.GET("/api/playlists/:id/shares", handlers.AuthMiddleware(cfg), handlers.ListSharesHandler(cfg, playlistStore))
+	r.POST("/api/playlists/:id/share", handlers.AuthMiddleware(cfg), handlers.CreateShareHandler(cfg, playlistStore))
+	r.DELETE("/api/playlists/:id/share/:shareId", handlers.AuthMiddleware(cfg), handlers.RevokeShareHandler(cfg, playlistStore))
 	
 	// Pages
 	r.GET("/player", handlers.AuthMiddleware(cfg), handlers.PlayerPage)
@@ -169,31 +169,158 @@ func main() {
 		c.HTML(200, "playlist.html", nil)
 	})
 
+	// Unmatched routes
+	r.NoRoute(handlers.NotFoundHandler(cfg))
+
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	listener, err := listen(cfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to listen: %v", err)
+	}
+	scheme := "http"
+	if cfg.TLSCert != "" {
+		scheme = "https"
+	}
+	if cfg.UnixSocket != "" {
+		log.Printf("🎬 Streamlet running on unix:%s (%s)", cfg.UnixSocket, scheme)
+	} else {
+		log.Printf("🎬 Streamlet running on %s://%s", scheme, listener.Addr())
 	}
-	log.Printf("🎬 Streamlet running on :%s", port)
 	log.Printf("📁 Video directories: %s", strings.Join(cfg.VideoDirs, ", "))
 	log.Printf("📊 Data directory: %s", cfg.DataDir)
 	
+	// Build the video index in background and keep it fresh
+	go videoIndex.Run(cfg.IndexRefreshInterval)
+	if cfg.WatchDirs {
+		watcher, err := handlers.NewDirWatcher(cfg, videoIndex, videoStore)
+		if err != nil {
+			log.Printf("❌ Failed to watch video directories: %v", err)
+		} else {
+			defer watcher.Close()
+			go watcher.Run()
+		}
+	}
+
+	// Move cache files if THUMBNAIL_LAYOUT changed, before anything looks them up
+	if moved, err := handlers.MigrateCacheLayout(cfg); err != nil {
+		log.Printf("❌ Cache layout migration failed: %v", err)
+	} else if moved > 0 {
+		log.Printf("📦 Moved %d cache files to the %s layout", moved, cfg.ThumbnailLayout)
+	}
+
+	// Working directories of generation killed mid-run are never removed by their defer
+	if removed, bytes := handlers.SweepTempDirs(cfg); removed > 0 {
+		log.Printf("🧹 Removed %d leftover temporary directories (%d MB)", removed, bytes/(1024*1024))
+	}
+
+	// Another instance sharing the thumbnail directory may be generating, so
+	// recent temp directories are kept like in the sweep above
+	if cfg.CleanupOnStart {
+		if result, err := handlers.Cleanup(cfg, videoStore, handlers.TempDirSweepAge, false); err != nil {
+			log.Printf("❌ Cleanup failed: %v", err)
+		} else if result.Count > 0 || result.TempDirs > 0 {
+			log.Printf("🧹 Removed %d orphaned cache files and %d temporary directories (%d MB)",
+				result.Count, result.TempDirs, result.TotalBytes/(1024*1024))
+		}
+	}
+
 	// Start thumbnail and preview generation in background on startup
-	go func() {
-		// Run thumbnail generation first (faster)
-		tg := handlers.NewThumbnailGenerator(cfg, videoStore, 4)
-		if err := tg.GenerateAll(); err != nil {
+	generateThumbnails := func() {
+		tg := handlers.NewThumbnailGenerator(cfg, videoStore, cfg.GenerationWorkers)
+		if err := tg.GenerateAll(ctx); err != nil && err != context.Canceled {
 			log.Printf("❌ Thumbnail generation error: %v", err)
 		}
-	}()
+	}
+	generatePreviews := func() {
+		if !cfg.PreviewsEnabled {
+			log.Printf("🎬 Preview generation disabled")
+			return
+		}
+		pg := handlers.NewPreviewGenerator(cfg, videoStore, cfg.GenerationWorkers)
+		if err := pg.GenerateAll(ctx); err != nil && err != context.Canceled {
+			log.Printf("❌ Preview generation error: %v", err)
+		}
+	}
+
+	if ffmpegErr != nil {
+		log.Printf("⚠️  Skipping startup thumbnail and preview generation without ffmpeg")
+	} else if cfg.GenerationMode == "sequential" {
+		// Thumbnails first (fast, immediately useful in the UI), then previews
+		generation.Add(1)
+		go func() {
+			defer generation.Done()
+			generateThumbnails()
+			generatePreviews()
+		}()
+	} else {
+		// Run both in parallel
+		generation.Add(2)
+		go func() {
+			defer generation.Done()
+			generateThumbnails()
+		}()
+		go func() {
+			defer generation.Done()
+			generatePreviews()
+		}()
+	}
 
+	srv := &http.Server{Handler: r}
 	go func() {
-		// Run preview generation in parallel
-		pg := handlers.NewPreviewGenerator(cfg, videoStore, 4)
-		if err := pg.GenerateAll(); err != nil {
-			log.Printf("❌ Preview generation error: %v", err)
+		var err error
+		if cfg.TLSCert != "" {
+			err = srv.ServeTLS(listener, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = srv.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("❌ Server error: %v", err)
 		}
 	}()
 
-	log.Fatal(r.Run(":" + port))
+	<-ctx.Done()
+	stop()
+	log.Printf("🛑 Shutting down, waiting up to %s for requests and generation jobs...", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  Server shutdown: %v", err)
+	}
+
+	// Generators stop taking new jobs once ctx is cancelled, wait for the running ones
+	done := make(chan struct{})
+	go func() {
+		generation.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Printf("⚠️  Generation jobs still running after %s, exiting anyway", cfg.ShutdownTimeout)
+	}
+
+	if removed, _ := handlers.SweepTempDirs(cfg); removed > 0 {
+		log.Printf("🧹 Removed %d temporary directories", removed)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("⚠️  Failed to close database: %v", err)
+	}
+	log.Printf("👋 Stopped")
+}
+
+// listen opens cfg.UnixSocket, or TCP on cfg.BindAddr
+// A socket file left by an unclean exit is replaced.
+func listen(cfg *config.Config) (net.Listener, error) {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, errors.New("TLS_CERT and TLS_KEY must be set together")
+	}
+	if cfg.UnixSocket == "" {
+		return net.Listen("tcp", cfg.BindAddr)
+	}
+	if info, err := os.Stat(cfg.UnixSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(cfg.UnixSocket)
+	}
+	return net.Listen("unix", cfg.UnixSocket)
 }
@@ -0,0 +1,60 @@
+// Keeps the access token cookie fresh using the refresh token cookie.
+// API calls answered with 401 "expired"/"missing" are retried once after a refresh;
+// the token is also refreshed shortly before it expires so long playback isn't interrupted.
+(function () {
+    const nativeFetch = window.fetch.bind(window);
+    let refreshing = null;
+    let refreshTimer = null;
+
+    // The server keeps the token in an httpOnly cookie, only its expiry is tracked here
+    function setTokenExpiry(expiresIn) {
+        localStorage.setItem('tokenExpiresAt', Date.now() + expiresIn * 1000);
+        scheduleRefresh(expiresIn);
+    }
+
+    function scheduleRefresh(expiresIn) {
+        clearTimeout(refreshTimer);
+        // Refresh at 80% of the lifetime, capped to what setTimeout supports
+        const delay = Math.min(expiresIn * 800, 2147483647);
+        refreshTimer = setTimeout(refreshToken, delay);
+    }
+
+    function tokenExpiresIn() {
+        const expiresAt = Number(localStorage.getItem('tokenExpiresAt'));
+        return expiresAt ? Math.max((expiresAt - Date.now()) / 1000, 0) : 0;
+    }
+
+    function refreshToken() {
+        if (!refreshing) {
+            refreshing = nativeFetch('/api/refresh', { method: 'POST' })
+                .then(async (res) => {
+                    if (!res.ok) return false;
+                    const data = await res.json();
+                    setTokenExpiry(data.expiresIn);
+                    return true;
+                })
+                .catch(() => false)
+                .finally(() => { refreshing = null; });
+        }
+        return refreshing;
+    }
+
+    window.fetch = async function (input, init) {
+        const response = await nativeFetch(input, init);
+        const url = typeof input === 'string' ? input : input.url;
+        if (response.status !== 401 || !url.startsWith('/api/') || url === '/api/refresh') return response;
+
+        const body = await response.clone().json().catch(() => ({}));
+        if (body.reason !== 'expired' && body.reason !== 'missing') return response;
+
+        if (await refreshToken()) return nativeFetch(input, init);
+        window.location.href = '/login';
+        return response;
+    };
+
+    window.refreshToken = refreshToken;
+    window.setTokenExpiry = setTokenExpiry;
+
+    const remaining = tokenExpiresIn();
+    if (remaining > 0) scheduleRefresh(remaining);
+})();
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Backup writes a consistent snapshot of the database to path, which must not exist
+// VACUUM INTO reads inside a single transaction, so the snapshot is consistent
+// even while other connections write to the WAL
+func (s *Storage) Backup(path string) error {
+	_, err := s.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// ValidateBackup checks that path is an intact streamlet database and upgrades
+// it to the current schema so it can be restored
+func ValidateBackup(path string) error {
+	db, err := openSQLite(path, 1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		db.Close()
+		// WAL mode leaves side files next to the upload
+		os.Remove(path + "-wal")
+		os.Remove(path + "-shm")
+	}()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("not a valid database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var tables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('video_stats', 'playlists')`).Scan(&tables)
+	if tables != 2 {
+		return fmt.Errorf("not a streamlet database")
+	}
+
+	if err := runMigrations(db); err != nil {
+		return err
+	}
+	// Checkpoint so the migrated schema is in the main file before it's attached
+	_, err = db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+// Restore replaces every table's contents with those of the validated backup at path
+// The copy runs in one transaction on the live database, so readers see either
+// the old or the restored data and open connections stay valid
+func (s *Storage) Restore(path string) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS restore`, path); err != nil {
+		return fmt.Errorf("failed to attach backup: %w", err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE restore`)
+
+	tables, err := restoreTables(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Rows are copied table by table, so check foreign keys only at commit
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return err
+	}
+	for table, columns := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM main.%s`, table)); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+		if columns == "" {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO main.%s (%s) SELECT %s FROM restore.%s`, table, columns, columns, table))
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreTables maps each data table of the live database to the column list
+// copied from the backup, empty when the backup doesn't have the table
+func restoreTables(ctx context.Context, conn *sql.Conn) (map[string]string, error) {
+	names, err := tableNames(ctx, conn, "main")
+	if err != nil {
+		return nil, err
+	}
+	backupNames, err := tableNames(ctx, conn, "restore")
+	if err != nil {
+		return nil, err
+	}
+	inBackup := make(map[string]bool)
+	for _, name := range backupNames {
+		inBackup[name] = true
+	}
+
+	tables := make(map[string]string)
+	for _, name := range names {
+		if !inBackup[name] {
+			tables[name] = ""
+			continue
+		}
+		rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT name FROM main.pragma_table_info('%s')`, name))
+		if err != nil {
+			return nil, err
+		}
+		var columns []string
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err == nil {
+				columns = append(columns, column)
+			}
+		}
+		rows.Close()
+		tables[name] = strings.Join(columns, ", ")
+	}
+	return tables, nil
+}
+
+// tableNames lists the data tables of an attached schema
+// schema_version is left alone since the backup was migrated to the same version
+func tableNames(ctx context.Context, conn *sql.Conn, schema string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(
+		`SELECT name FROM %s.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%%' AND name != 'schema_version'`, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
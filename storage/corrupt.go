@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CorruptVideo is a video file that failed validation
+// Size and ModTime identify the file version that failed, so a replaced file
+// is validated again instead of staying quarantined.
+type CorruptVideo struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// Matches reports whether the record is for a file of this size and modification time
+func (v CorruptVideo) Matches(size int64, modTime time.Time) bool {
+	return v.Size == size && v.ModTime.Equal(modTime)
+}
+
+// GetCorruptVideos returns the quarantined videos keyed by prefixed path
+func (s *Storage) GetCorruptVideos() map[string]CorruptVideo {
+	videos := make(map[string]CorruptVideo)
+	rows, err := s.db.Query(`SELECT path, size, mod_time, reason, detected_at FROM corrupt_videos`)
+	if err != nil {
+		return videos
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v CorruptVideo
+		var modTime int64
+		var detectedAt sql.NullTime
+		if err := rows.Scan(&v.Path, &v.Size, &modTime, &v.Reason, &detectedAt); err != nil {
+			continue
+		}
+		v.ModTime = time.Unix(0, modTime)
+		if detectedAt.Valid {
+			v.DetectedAt = detectedAt.Time
+		}
+		videos[v.Path] = v
+	}
+	return videos
+}
+
+// SetCorruptVideo quarantines a video file with the reason it failed validation
+func (s *Storage) SetCorruptVideo(path string, size int64, modTime time.Time, reason string) {
+	s.db.Exec(`
+		INSERT INTO corrupt_videos (path, size, mod_time, reason, detected_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			size = excluded.size,
+			mod_time = excluded.mod_time,
+			reason = excluded.reason,
+			detected_at = CURRENT_TIMESTAMP
+	`, path, size, modTime.UnixNano(), reason)
+}
+
+// ClearCorruptVideo removes a video from quarantine
+func (s *Storage) ClearCorruptVideo(path string) {
+	s.db.Exec(`DELETE FROM corrupt_videos WHERE path = ?`, path)
+}
+
+// GetCorruptVideo returns the quarantine record of a video, if any
+func (s *Storage) GetCorruptVideo(path string) (CorruptVideo, bool) {
+	v := CorruptVideo{Path: path}
+	var modTime int64
+	var detectedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT size, mod_time, reason, detected_at FROM corrupt_videos WHERE path = ?
+	`, path).Scan(&v.Size, &modTime, &v.Reason, &detectedAt)
+	if err != nil {
+		return v, false
+	}
+	v.ModTime = time.Unix(0, modTime)
+	if detectedAt.Valid {
+		v.DetectedAt = detectedAt.Time
+	}
+	return v, true
+}
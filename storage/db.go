@@ -12,122 +12,79 @@ import (
 
 var (
 	dbInstance *sql.DB
+	dbDir      string
 	dbOnce     sync.Once
 	dbMutex    sync.RWMutex
+	dbInitErr  error
 )
 
-func InitDB(dataDir string) (*sql.DB, error) {
-	var initErr error
-	dbOnce.Do(func() {
-		if err := os.MkdirAll(dataDir, 0755); err != nil {
-			initErr = fmt.Errorf("failed to create data directory: %w", err)
-			return
-		}
-
-		dbPath := filepath.Join(dataDir, "streamlet.db")
-		dsn := fmt.Sprintf("%s?_journal_mode=WAL&_foreign_keys=on", dbPath)
-
-		db, err := sql.Open("sqlite", dsn)
-		if err != nil {
-			initErr = fmt.Errorf("failed to open database: %w", err)
-			return
-		}
-
-		db.SetMaxOpenConns(1)
-		db.SetMaxIdleConns(1)
-
-		if err := db.Ping(); err != nil {
-			initErr = fmt.Errorf("failed to ping database: %w", err)
-			return
-		}
-
-		if err := runMigrations(db); err != nil {
-			initErr = fmt.Errorf("failed to run migrations: %w", err)
-			return
-		}
-
-		dbInstance = db
-	})
-
-	if initErr != nil {
-		return nil, initErr
+// OpenDB opens the database in dataDir and runs migrations
+// Every call opens a new pool; maxOpenConns limits its connections
+// (values below 1 mean 1). WAL mode lets readers run alongside a writer,
+// and the busy timeout makes concurrent writers wait instead of failing.
+func OpenDB(dataDir string, maxOpenConns int) (*sql.DB, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
-	return dbInstance, nil
-}
 
-func runMigrations(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS video_stats (
-			path TEXT PRIMARY KEY,
-			name TEXT NOT NULL DEFAULT '',
-			views INTEGER NOT NULL DEFAULT 0,
-			likes INTEGER NOT NULL DEFAULT 0,
-			liked INTEGER NOT NULL DEFAULT 0,
-			last_viewed DATETIME,
-			hotness REAL NOT NULL DEFAULT 0,
-			thumbnail_hash TEXT,
-			preview_hash TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+	db, err := openSQLite(filepath.Join(dataDir, "streamlet.db"), maxOpenConns)
 	if err != nil {
-		return fmt.Errorf("failed to create video_stats table: %w", err)
+		return nil, err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS playlists (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT DEFAULT '',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create playlists table: %w", err)
+	if err := runMigrations(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS playlist_videos (
-			playlist_id TEXT NOT NULL,
-			video_path TEXT NOT NULL,
-			position INTEGER NOT NULL DEFAULT 0,
-			added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (playlist_id, video_path),
-			FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create playlist_videos table: %w", err)
-	}
+	return db, nil
+}
 
-	indexes := []string{
-		`CREATE INDEX IF NOT EXISTS idx_video_stats_hotness ON video_stats(hotness DESC)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_stats_views ON video_stats(views DESC)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_stats_likes ON video_stats(likes DESC)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_stats_last_viewed ON video_stats(last_viewed DESC)`,
-		`CREATE INDEX IF NOT EXISTS idx_playlist_videos_playlist_id ON playlist_videos(playlist_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_playlists_updated_at ON playlists(updated_at DESC)`,
+// openSQLite opens and pings the SQLite file at dbPath without migrating it
+func openSQLite(dbPath string, maxOpenConns int) (*sql.DB, error) {
+	if maxOpenConns < 1 {
+		maxOpenConns = 1
 	}
 
-	for _, indexSQL := range indexes {
-		if _, err := db.Exec(indexSQL); err != nil {
-			return fmt.Errorf("failed to create index: %w", err)
-		}
-	}
-	// Add new columns if they don't exist (migration for existing databases)
-	_, err = db.Exec(`ALTER TABLE video_stats ADD COLUMN thumbnail_hash TEXT`)
+	// Pragmas are applied to every connection in the pool; immediate
+	// transactions take the write lock up front so they can't deadlock
+	dsn := fmt.Sprintf("%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate", dbPath)
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		// Column already exists, ignore error
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	_, err = db.Exec(`ALTER TABLE video_stats ADD COLUMN preview_hash TEXT`)
-	if err != nil {
-		// Column already exists, ignore error
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
+	return db, nil
+}
 
-	return nil
+// InitDB returns the shared single-connection database for dataDir, opening
+// it on the first call. Calling it again with a different dataDir is an error
+// rather than silently returning the first database.
+func InitDB(dataDir string) (*sql.DB, error) {
+	dbOnce.Do(func() {
+		db, err := OpenDB(dataDir, 1)
+		dbMutex.Lock()
+		defer dbMutex.Unlock()
+		dbInstance, dbDir, dbInitErr = db, dataDir, err
+	})
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	if dbInitErr != nil {
+		return nil, dbInitErr
+	}
+	if filepath.Clean(dataDir) != filepath.Clean(dbDir) {
+		return nil, fmt.Errorf("database already opened in %s, not %s", dbDir, dataDir)
+	}
+	return dbInstance, nil
 }
 
 func GetDB() *sql.DB {
@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ExportVersion is the format version written by Export and accepted by Import
+const ExportVersion = 1
+
+// ExportData is a portable JSON snapshot of stats, tags and playlists
+// Videos are identified by content hash so they can be found again after the
+// library moves; the path is kept as a fallback for videos without a hash.
+type ExportData struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Videos     []ExportVideo    `json:"videos"`
+	Playlists  []ExportPlaylist `json:"playlists"`
+}
+
+// ExportVideo holds the totals, tags and per-user stats of one video
+type ExportVideo struct {
+	Hash         string            `json:"hash,omitempty"`
+	Path         string            `json:"path"`
+	Name         string            `json:"name,omitempty"`
+	Views        int               `json:"views"`
+	Likes        int               `json:"likes"`
+	LastViewed   *time.Time        `json:"lastViewed,omitempty"`
+	PositionSec  float64           `json:"positionSec,omitempty"`
+	WatchSeconds float64           `json:"watchSeconds,omitempty"`
+	Completed    bool              `json:"completed,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Users        []ExportUserStats `json:"users,omitempty"`
+}
+
+// ExportUserStats holds one user's stats for a video
+type ExportUserStats struct {
+	Username     string     `json:"username"`
+	Views        int        `json:"views"`
+	Liked        bool       `json:"liked,omitempty"`
+	Bookmarked   bool       `json:"bookmarked,omitempty"`
+	LastViewed   *time.Time `json:"lastViewed,omitempty"`
+	PositionSec  float64    `json:"positionSec,omitempty"`
+	WatchSeconds float64    `json:"watchSeconds,omitempty"`
+	Completed    bool       `json:"completed,omitempty"`
+}
+
+// ExportVideoRef identifies a video inside an exported playlist
+type ExportVideoRef struct {
+	Hash string `json:"hash,omitempty"`
+	Path string `json:"path"`
+}
+
+// ExportPlaylist is a playlist with its videos in order
+type ExportPlaylist struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Type        string           `json:"type"`
+	Rules       *PlaylistRules   `json:"rules,omitempty"`
+	CoverVideo  *ExportVideoRef  `json:"coverVideo,omitempty"`
+	Videos      []ExportVideoRef `json:"videos,omitempty"`
+}
+
+// ImportReport describes what an import changed, or would change in a dry run
+type ImportReport struct {
+	DryRun              bool     `json:"dryRun"`
+	MatchedByHash       int      `json:"matchedByHash"`
+	MatchedByPath       int      `json:"matchedByPath"`
+	Unmatched           []string `json:"unmatched"` // Exported paths with no video in the library
+	VideosUpdated       int      `json:"videosUpdated"`
+	TagsAdded           int      `json:"tagsAdded"`
+	PlaylistsCreated    int      `json:"playlistsCreated"`
+	PlaylistsMerged     int      `json:"playlistsMerged"`
+	PlaylistVideosAdded int      `json:"playlistVideosAdded"`
+}
+
+// VideoResolver maps an exported video to a path in the current library
+// byHash reports whether it was matched by content hash; ok is false if no video matches
+type VideoResolver func(hash, path string) (target string, byHash, ok bool)
+
+// nullTimePtr converts a nullable column to an optional JSON time
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// Export collects all stats, tags and playlists
+// hashOf returns the content hash of a current video, or "" to use the stored hash
+func (s *Storage) Export(hashOf func(path string) string) (*ExportData, error) {
+	data := &ExportData{Version: ExportVersion, ExportedAt: time.Now(), Videos: []ExportVideo{}, Playlists: []ExportPlaylist{}}
+
+	rows, err := s.db.Query(`
+		SELECT path, name, COALESCE(NULLIF(content_hash, ''), thumbnail_hash, ''), views, likes, last_viewed,
+			position_sec, watch_seconds, completed
+		FROM video_stats ORDER BY path
+	`)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for rows.Next() {
+		var v ExportVideo
+		var lastViewed sql.NullTime
+		if err := rows.Scan(&v.Path, &v.Name, &v.Hash, &v.Views, &v.Likes, &lastViewed,
+			&v.PositionSec, &v.WatchSeconds, &v.Completed); err != nil {
+			continue
+		}
+		v.LastViewed = nullTimePtr(lastViewed)
+		index[v.Path] = len(data.Videos)
+		data.Videos = append(data.Videos, v)
+	}
+	rows.Close()
+
+	// Tagged videos don't necessarily have stats
+	video := func(path string) *ExportVideo {
+		i, ok := index[path]
+		if !ok {
+			i = len(data.Videos)
+			index[path] = i
+			data.Videos = append(data.Videos, ExportVideo{Path: path})
+		}
+		return &data.Videos[i]
+	}
+
+	rows, err = s.db.Query(`
+		SELECT username, path, views, liked, bookmarked, last_viewed, position_sec, watch_seconds, completed
+		FROM user_video_stats ORDER BY path, username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u ExportUserStats
+		var path string
+		var lastViewed sql.NullTime
+		if err := rows.Scan(&u.Username, &path, &u.Views, &u.Liked, &u.Bookmarked, &lastViewed,
+			&u.PositionSec, &u.WatchSeconds, &u.Completed); err != nil {
+			continue
+		}
+		u.LastViewed = nullTimePtr(lastViewed)
+		v := video(path)
+		v.Users = append(v.Users, u)
+	}
+	rows.Close()
+
+	for path, tags := range s.GetAllVideoTags() {
+		video(path).Tags = tags
+	}
+
+	hash := func(path string) string {
+		if h := hashOf(path); h != "" {
+			return h
+		}
+		if i, ok := index[path]; ok {
+			return data.Videos[i].Hash
+		}
+		return ""
+	}
+	for i := range data.Videos {
+		data.Videos[i].Hash = hash(data.Videos[i].Path)
+	}
+
+	playlists, err := s.exportPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	for i := range playlists {
+		p := &playlists[i]
+		for j := range p.Videos {
+			p.Videos[j].Hash = hash(p.Videos[j].Path)
+		}
+		if p.CoverVideo != nil {
+			p.CoverVideo.Hash = hash(p.CoverVideo.Path)
+		}
+	}
+	data.Playlists = playlists
+	return data, nil
+}
+
+// exportPlaylists reads all playlists with their stored video paths
+func (s *Storage) exportPlaylists() ([]ExportPlaylist, error) {
+	rows, err := s.db.Query(`SELECT id, name, COALESCE(description, ''), type, rules, cover_video FROM playlists ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	playlists := []ExportPlaylist{}
+	for rows.Next() {
+		var id, rules, cover string
+		var p ExportPlaylist
+		if err := rows.Scan(&id, &p.Name, &p.Description, &p.Type, &rules, &cover); err != nil {
+			continue
+		}
+		p.Rules = decodeRules(rules)
+		if cover != "" {
+			p.CoverVideo = &ExportVideoRef{Path: cover}
+		}
+		ids = append(ids, id)
+		playlists = append(playlists, p)
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		rows, err := s.db.Query(`SELECT video_path FROM playlist_videos WHERE playlist_id = ? ORDER BY position`, id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err == nil {
+				playlists[i].Videos = append(playlists[i].Videos, ExportVideoRef{Path: path})
+			}
+		}
+		rows.Close()
+	}
+	return playlists, nil
+}
+
+// Import merges exported data into the database
+// Counters take the larger of the stored and imported value, so importing the
+// same file twice changes nothing. Playlists are matched by name; videos are
+// added to existing ones and missing ones are created. With dryRun the changes
+// are made in a transaction that is rolled back, so the report is exact.
+func (s *Storage) Import(data *ExportData, resolve VideoResolver, dryRun bool) (*ImportReport, error) {
+	if data.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", data.Version)
+	}
+
+	report := &ImportReport{DryRun: dryRun, Unmatched: []string{}}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var touched []string
+	for _, v := range data.Videos {
+		target, byHash, ok := resolve(v.Hash, v.Path)
+		if !ok {
+			report.Unmatched = append(report.Unmatched, v.Path)
+			continue
+		}
+		if byHash {
+			report.MatchedByHash++
+		} else {
+			report.MatchedByPath++
+		}
+
+		changed, err := importVideoStats(tx, target, v)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range v.Users {
+			userChanged, err := importUserStats(tx, target, u)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || userChanged
+		}
+		if changed {
+			report.VideosUpdated++
+			touched = append(touched, target)
+		}
+
+		for _, tag := range v.Tags {
+			added, err := importTag(tx, target, tag)
+			if err != nil {
+				return nil, err
+			}
+			if added {
+				report.TagsAdded++
+			}
+		}
+	}
+
+	for i, p := range data.Playlists {
+		if err := importPlaylist(tx, p, i, resolve, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, path := range touched {
+		s.updateHotness(path)
+	}
+	return report, nil
+}
+
+// laterTime returns the later of a stored and an imported time
+func laterTime(stored sql.NullTime, imported *time.Time) sql.NullTime {
+	if imported != nil && (!stored.Valid || imported.After(stored.Time)) {
+		return sql.NullTime{Time: *imported, Valid: true}
+	}
+	return stored
+}
+
+// importVideoStats merges totals into a video's stats row
+func importVideoStats(tx *sql.Tx, path string, v ExportVideo) (bool, error) {
+	var name string
+	var views, likes int
+	var lastViewed sql.NullTime
+	var position, watched float64
+	var completed bool
+	err := tx.QueryRow(`
+		SELECT name, views, likes, last_viewed, position_sec, watch_seconds, completed FROM video_stats WHERE path = ?
+	`, path).Scan(&name, &views, &likes, &lastViewed, &position, &watched, &completed)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	exists := err == nil
+
+	newViews, newLikes := max(views, v.Views), max(likes, v.Likes)
+	newLastViewed := laterTime(lastViewed, v.LastViewed)
+	newWatched, newCompleted := max(watched, v.WatchSeconds), completed || v.Completed
+	newPosition := position
+	if newPosition == 0 {
+		newPosition = v.PositionSec
+	}
+	if name == "" {
+		name = v.Name
+	}
+
+	if exists && newViews == views && newLikes == likes && newLastViewed == lastViewed &&
+		newWatched == watched && newCompleted == completed && newPosition == position {
+		return false, nil
+	}
+	if !exists && v.Views == 0 && v.Likes == 0 && v.LastViewed == nil && v.WatchSeconds == 0 && v.PositionSec == 0 && !v.Completed {
+		return false, nil // Nothing worth a row, e.g. a video that only has tags
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO video_stats (path, name, views, likes, last_viewed, position_sec, watch_seconds, completed, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			name = excluded.name,
+			views = excluded.views,
+			likes = excluded.likes,
+			last_viewed = excluded.last_viewed,
+			position_sec = excluded.position_sec,
+			watch_seconds = excluded.watch_seconds,
+			completed = excluded.completed,
+			updated_at = CURRENT_TIMESTAMP
+	`, path, name, newViews, newLikes, newLastViewed, newPosition, newWatched, newCompleted)
+	return err == nil, err
+}
+
+// importUserStats merges one user's stats for a video
+func importUserStats(tx *sql.Tx, path string, u ExportUserStats) (bool, error) {
+	var views int
+	var liked, bookmarked, completed bool
+	var lastViewed sql.NullTime
+	var position, watched float64
+	err := tx.QueryRow(`
+		SELECT views, liked, bookmarked, last_viewed, position_sec, watch_seconds, completed
+		FROM user_video_stats WHERE username = ? AND path = ?
+	`, u.Username, path).Scan(&views, &liked, &bookmarked, &lastViewed, &position, &watched, &completed)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	exists := err == nil
+
+	newViews, newLiked, newBookmarked := max(views, u.Views), liked || u.Liked, bookmarked || u.Bookmarked
+	newLastViewed := laterTime(lastViewed, u.LastViewed)
+	newWatched, newCompleted := max(watched, u.WatchSeconds), completed || u.Completed
+	newPosition := position
+	if newPosition == 0 {
+		newPosition = u.PositionSec
+	}
+
+	if exists && newViews == views && newLiked == liked && newBookmarked == bookmarked && newLastViewed == lastViewed &&
+		newWatched == watched && newCompleted == completed && newPosition == position {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_video_stats (username, path, views, liked, bookmarked, last_viewed, position_sec, watch_seconds, completed, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			views = excluded.views,
+			liked = excluded.liked,
+			bookmarked = excluded.bookmarked,
+			last_viewed = excluded.last_viewed,
+			position_sec = excluded.position_sec,
+			watch_seconds = excluded.watch_seconds,
+			completed = excluded.completed,
+			updated_at = CURRENT_TIMESTAMP
+	`, u.Username, path, newViews, newLiked, newBookmarked, newLastViewed, newPosition, newWatched, newCompleted)
+	return err == nil, err
+}
+
+// importTag attaches a tag, reporting whether the video didn't have it yet
+func importTag(tx *sql.Tx, path, tag string) (bool, error) {
+	tag = NormalizeTag(tag)
+	if tag == "" {
+		return false, nil
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO tags (name) VALUES (?)`, tag); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO video_tags (video_path, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+	`, path, tag)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// importPlaylist merges a playlist into the one with the same name, or creates it
+// n makes IDs of playlists created in the same second unique
+func importPlaylist(tx *sql.Tx, p ExportPlaylist, n int, resolve VideoResolver, report *ImportReport) error {
+	if p.Type != PlaylistSmart {
+		p.Type = PlaylistStatic
+	}
+
+	now := time.Now()
+	var id, cover string
+	err := tx.QueryRow(`SELECT id, cover_video FROM playlists WHERE name = ? ORDER BY created_at LIMIT 1`, p.Name).Scan(&id, &cover)
+	if err == sql.ErrNoRows {
+		id = fmt.Sprintf("%s-%d", generateID(), n+1)
+		_, err = tx.Exec(`
+			INSERT INTO playlists (id, name, description, type, rules, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, id, p.Name, p.Description, p.Type, encodeRules(p.Rules), now, now)
+		if err != nil {
+			return err
+		}
+		report.PlaylistsCreated++
+	} else if err != nil {
+		return err
+	} else {
+		report.PlaylistsMerged++
+	}
+
+	if cover == "" && p.CoverVideo != nil {
+		if target, _, ok := resolve(p.CoverVideo.Hash, p.CoverVideo.Path); ok {
+			tx.Exec(`UPDATE playlists SET cover_video = ? WHERE id = ?`, target, id)
+		}
+	}
+
+	var maxPos int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(position), -1) FROM playlist_videos WHERE playlist_id = ?`, id).Scan(&maxPos); err != nil {
+		return err
+	}
+	added := 0
+	for _, ref := range p.Videos {
+		target, _, ok := resolve(ref.Hash, ref.Path)
+		if !ok {
+			continue
+		}
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO playlist_videos (playlist_id, video_path, position, added_at)
+			VALUES (?, ?, ?, ?)
+		`, id, target, maxPos+1, now)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			maxPos++
+			added++
+		}
+	}
+	if added > 0 {
+		tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, now, id)
+	}
+	report.PlaylistVideosAdded += added
+	return nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// HistoryEntry is a video in a user's watch history
+type HistoryEntry struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	ViewedAt time.Time `json:"viewedAt"`
+	Views    int       `json:"views,omitempty"` // The user's views of the video, only in the per-video history
+}
+
+// GetHistory returns a page of a user's watch history, most recent first, and the total count
+// By default each video appears once at its latest view; with events set every logged view is listed
+func (s *Storage) GetHistory(username string, events bool, offset, limit int) ([]HistoryEntry, int) {
+	var countQuery, query string
+	if events {
+		countQuery = `SELECT COUNT(*) FROM view_history WHERE username = ?`
+		query = `
+			SELECT h.path, COALESCE(v.name, ''), h.viewed_at, 0
+			FROM view_history h
+			LEFT JOIN video_stats v ON v.path = h.path
+			WHERE h.username = ?
+			ORDER BY h.viewed_at DESC, h.id DESC
+			LIMIT ? OFFSET ?
+		`
+	} else {
+		countQuery = `SELECT COUNT(*) FROM user_video_stats WHERE username = ? AND last_viewed IS NOT NULL`
+		query = `
+			SELECT u.path, COALESCE(v.name, ''), u.last_viewed, u.views
+			FROM user_video_stats u
+			LEFT JOIN video_stats v ON v.path = u.path
+			WHERE u.username = ? AND u.last_viewed IS NOT NULL
+			ORDER BY u.last_viewed DESC, u.path
+			LIMIT ? OFFSET ?
+		`
+	}
+
+	entries := []HistoryEntry{}
+
+	var total int
+	if err := s.db.QueryRow(countQuery, username).Scan(&total); err != nil {
+		return entries, 0
+	}
+
+	rows, err := s.db.Query(query, username, limit, offset)
+	if err != nil {
+		return entries, total
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry HistoryEntry
+		var viewedAt sql.NullTime
+		if err := rows.Scan(&entry.Path, &entry.Name, &viewedAt, &entry.Views); err != nil {
+			continue
+		}
+		if viewedAt.Valid {
+			entry.ViewedAt = viewedAt.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total
+}
+
+// InProgressEntry is a video a user started watching but didn't finish
+type InProgressEntry struct {
+	Path        string
+	PositionSec float64
+	LastWatched time.Time
+}
+
+// GetInProgress returns the videos a user stopped watching past minPosition
+// seconds and never finished, most recently watched first
+// Positions near the end are reset when reported, so these are all unfinished.
+func (s *Storage) GetInProgress(username string, minPosition float64) []InProgressEntry {
+	rows, err := s.db.Query(`
+		SELECT path, position_sec, last_viewed, updated_at
+		FROM user_video_stats
+		WHERE username = ? AND position_sec >= ? AND completed = 0
+		ORDER BY COALESCE(last_viewed, updated_at) DESC, path
+	`, username, minPosition)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var entries []InProgressEntry
+	for rows.Next() {
+		var entry InProgressEntry
+		var lastViewed, updatedAt sql.NullTime
+		if err := rows.Scan(&entry.Path, &entry.PositionSec, &lastViewed, &updatedAt); err != nil {
+			continue
+		}
+		if lastViewed.Valid {
+			entry.LastWatched = lastViewed.Time
+		} else if updatedAt.Valid {
+			entry.LastWatched = updatedAt.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ClearHistory forgets when a user watched videos
+// Likes, positions and view counts are kept
+func (s *Storage) ClearHistory(username string) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM view_history WHERE username = ?`, username); err != nil {
+		return false
+	}
+	if _, err := tx.Exec(`UPDATE user_video_stats SET last_viewed = NULL WHERE username = ?`, username); err != nil {
+		return false
+	}
+	return tx.Commit() == nil
+}
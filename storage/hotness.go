@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/kitsnail/streamlet/config"
+)
+
+// watchSecondsPerView is how much watch time counts as much as one view
+// when hotness is weighted by watch time
+const watchSecondsPerView = 300.0
+
+// HotnessBreakdown is the hotness score split into its components
+type HotnessBreakdown struct {
+	Views        int     `json:"views"`
+	Likes        int     `json:"likes"`
+	WatchSeconds float64 `json:"watchSeconds"`
+	ViewScore    float64 `json:"viewScore"`    // views (or watch time) × view weight
+	LikeScore    float64 `json:"likeScore"`    // likes × like weight
+	RecencyBonus float64 `json:"recencyBonus"` // Decayed bonus since the last view
+	Total        float64 `json:"total"`
+}
+
+// ComputeHotness applies the hotness formula to a video's stats at the given time
+func ComputeHotness(hc config.HotnessConfig, views, likes int, watchSeconds float64, lastViewed time.Time, now time.Time) HotnessBreakdown {
+	b := HotnessBreakdown{Views: views, Likes: likes, WatchSeconds: watchSeconds}
+
+	viewCount := float64(views)
+	if hc.ByWatchTime {
+		viewCount = watchSeconds / watchSecondsPerView
+	}
+	b.ViewScore = viewCount * hc.ViewWeight
+	b.LikeScore = float64(likes) * hc.LikeWeight
+
+	// Recency bonus decays exponentially since the last view
+	if !lastViewed.IsZero() && hc.HalfLife > 0 {
+		age := math.Max(now.Sub(lastViewed).Seconds(), 0)
+		b.RecencyBonus = hc.RecencyBonus * math.Pow(0.5, age/hc.HalfLife.Seconds())
+	}
+
+	b.Total = b.ViewScore + b.LikeScore + b.RecencyBonus
+	return b
+}
+
+// HotnessBreakdown returns the current hotness components for a video path
+func (s *Storage) HotnessBreakdown(path string) HotnessBreakdown {
+	var views, likes int
+	var watchSeconds float64
+	var lastViewed sql.NullTime
+	s.db.QueryRow(`
+		SELECT views, likes, last_viewed, watch_seconds FROM video_stats WHERE path = ?
+	`, path).Scan(&views, &likes, &lastViewed, &watchSeconds)
+
+	var lastViewedAt time.Time
+	if lastViewed.Valid {
+		lastViewedAt = lastViewed.Time
+	}
+	return ComputeHotness(s.hotness, views, likes, watchSeconds, lastViewedAt, time.Now())
+}
@@ -0,0 +1,61 @@
+package storage
+
+import "time"
+
+// MediaMetadata is the probed stream information of a video
+type MediaMetadata struct {
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	VideoCodec string `json:"videoCodec"`
+	AudioCodec string `json:"audioCodec"`
+	Bitrate    int64  `json:"bitrate"` // Overall bitrate in bits per second
+}
+
+// GetMetadata returns the cached metadata for a content hash, or nil if it hasn't been probed
+func (s *Storage) GetMetadata(hash string) *MediaMetadata {
+	var m MediaMetadata
+	err := s.db.QueryRow(`
+		SELECT width, height, video_codec, audio_codec, bitrate
+		FROM media_metadata WHERE hash = ?
+	`, hash).Scan(&m.Width, &m.Height, &m.VideoCodec, &m.AudioCodec, &m.Bitrate)
+	if err != nil {
+		return nil
+	}
+	return &m
+}
+
+// SetMetadata caches the metadata for a content hash
+func (s *Storage) SetMetadata(hash string, m *MediaMetadata) {
+	s.db.Exec(`
+		INSERT INTO media_metadata (hash, width, height, video_codec, audio_codec, bitrate, probed_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(hash) DO UPDATE SET
+			width = excluded.width,
+			height = excluded.height,
+			video_codec = excluded.video_codec,
+			audio_codec = excluded.audio_codec,
+			bitrate = excluded.bitrate,
+			probed_at = CURRENT_TIMESTAMP
+	`, hash, m.Width, m.Height, m.VideoCodec, m.AudioCodec, m.Bitrate)
+}
+
+// GetDuration returns the cached duration for a content hash
+func (s *Storage) GetDuration(hash string) (time.Duration, bool) {
+	var ms int64
+	err := s.db.QueryRow(`SELECT duration_ms FROM video_durations WHERE hash = ?`, hash).Scan(&ms)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// SetDuration caches the duration for a content hash
+func (s *Storage) SetDuration(hash string, d time.Duration) {
+	s.db.Exec(`
+		INSERT INTO video_durations (hash, duration_ms, probed_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(hash) DO UPDATE SET
+			duration_ms = excluded.duration_ms,
+			probed_at = CURRENT_TIMESTAMP
+	`, hash, d.Milliseconds())
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is one step of the schema history
+// Steps are applied in order, each in its own transaction, and never edited
+// once released; schema changes are made by appending a new step.
+type migration struct {
+	name  string
+	apply func(tx *sql.Tx) error
+}
+
+// migrations is the ordered schema history; a step's version is its index + 1
+var migrations = []migration{
+	{name: "create tables", apply: execAll(
+		`
+			CREATE TABLE IF NOT EXISTS video_stats (
+				path TEXT PRIMARY KEY,
+				name TEXT NOT NULL DEFAULT '',
+				views INTEGER NOT NULL DEFAULT 0,
+				likes INTEGER NOT NULL DEFAULT 0,
+				liked INTEGER NOT NULL DEFAULT 0,
+				last_viewed DATETIME,
+				hotness REAL NOT NULL DEFAULT 0,
+				thumbnail_hash TEXT,
+				preview_hash TEXT,
+				position_sec REAL NOT NULL DEFAULT 0,
+				watch_seconds REAL NOT NULL DEFAULT 0,
+				completed INTEGER NOT NULL DEFAULT 0,
+				content_hash TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS playlists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				description TEXT DEFAULT '',
+				type TEXT NOT NULL DEFAULT 'static',
+				rules TEXT NOT NULL DEFAULT '',
+				cover_video TEXT NOT NULL DEFAULT '',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS playlist_videos (
+				playlist_id TEXT NOT NULL,
+				video_path TEXT NOT NULL,
+				position INTEGER NOT NULL DEFAULT 0,
+				added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (playlist_id, video_path),
+				FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS playlist_shares (
+				id TEXT PRIMARY KEY,
+				playlist_id TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				expires_at DATETIME,
+				revoked_at DATETIME,
+				FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS tags (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL UNIQUE,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS video_tags (
+				video_path TEXT NOT NULL,
+				tag_id INTEGER NOT NULL,
+				added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (video_path, tag_id),
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS media_metadata (
+				hash TEXT PRIMARY KEY,
+				width INTEGER NOT NULL DEFAULT 0,
+				height INTEGER NOT NULL DEFAULT 0,
+				video_codec TEXT NOT NULL DEFAULT '',
+				audio_codec TEXT NOT NULL DEFAULT '',
+				bitrate INTEGER NOT NULL DEFAULT 0,
+				probed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS users (
+				username TEXT PRIMARY KEY,
+				password_hash TEXT NOT NULL,
+				admin INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS user_video_stats (
+				username TEXT NOT NULL,
+				path TEXT NOT NULL,
+				views INTEGER NOT NULL DEFAULT 0,
+				liked INTEGER NOT NULL DEFAULT 0,
+				last_viewed DATETIME,
+				position_sec REAL NOT NULL DEFAULT 0,
+				watch_seconds REAL NOT NULL DEFAULT 0,
+				completed INTEGER NOT NULL DEFAULT 0,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (username, path)
+			)
+			`,
+		`
+			CREATE TABLE IF NOT EXISTS view_history (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL,
+				path TEXT NOT NULL,
+				viewed_at DATETIME NOT NULL
+			)
+			`,
+	)},
+	// Databases created before versioning may predate these columns
+	{name: "add columns from unversioned releases", apply: func(tx *sql.Tx) error {
+		columns := []struct{ table, column, definition string }{
+			{"video_stats", "thumbnail_hash", "TEXT"},
+			{"video_stats", "preview_hash", "TEXT"},
+			{"video_stats", "position_sec", "REAL NOT NULL DEFAULT 0"},
+			{"video_stats", "watch_seconds", "REAL NOT NULL DEFAULT 0"},
+			{"video_stats", "completed", "INTEGER NOT NULL DEFAULT 0"},
+			{"video_stats", "content_hash", "TEXT"},
+			{"playlists", "type", "TEXT NOT NULL DEFAULT 'static'"},
+			{"playlists", "rules", "TEXT NOT NULL DEFAULT ''"},
+			{"playlists", "cover_video", "TEXT NOT NULL DEFAULT ''"},
+		}
+		for _, col := range columns {
+			if err := addColumn(tx, col.table, col.column, col.definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{name: "create indexes", apply: execAll(
+		`CREATE INDEX IF NOT EXISTS idx_video_stats_hotness ON video_stats(hotness DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_video_stats_views ON video_stats(views DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_video_stats_likes ON video_stats(likes DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_video_stats_last_viewed ON video_stats(last_viewed DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_playlist_videos_playlist_id ON playlist_videos(playlist_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_playlists_updated_at ON playlists(updated_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_video_tags_tag_id ON video_tags(tag_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_video_stats_path ON user_video_stats(path)`,
+		`CREATE INDEX IF NOT EXISTS idx_playlist_shares_playlist_id ON playlist_shares(playlist_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_view_history_username ON view_history(username, viewed_at)`,
+	)},
+	{name: "create video_durations", apply: execAll(
+		`
+			CREATE TABLE IF NOT EXISTS video_durations (
+				hash TEXT PRIMARY KEY,
+				duration_ms INTEGER NOT NULL,
+				probed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+	)},
+	{name: "create corrupt_videos", apply: execAll(
+		`
+			CREATE TABLE IF NOT EXISTS corrupt_videos (
+				path TEXT PRIMARY KEY,
+				size INTEGER NOT NULL,
+				mod_time INTEGER NOT NULL,
+				reason TEXT NOT NULL,
+				detected_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)
+			`,
+	)},
+	{name: "add bookmarked to user_video_stats", apply: func(tx *sql.Tx) error {
+		return addColumn(tx, "user_video_stats", "bookmarked", "INTEGER NOT NULL DEFAULT 0")
+	}},
+	{name: "add custom_thumbnail to video_stats", apply: func(tx *sql.Tx) error {
+		return addColumn(tx, "video_stats", "custom_thumbnail", "TEXT")
+	}},
+	// Existing rows are backfilled with their file's modification time by the
+	// first RecordFirstSeen after upgrading, the migration can't see the files
+	{name: "add first_seen to video_stats", apply: func(tx *sql.Tx) error {
+		return addColumn(tx, "video_stats", "first_seen", "DATETIME")
+	}},
+}
+
+// execAll returns a migration step that runs statements in order
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column unless the table already has it
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// SchemaVersion returns the version of the last applied migration
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// runMigrations applies migrations newer than the database's schema version
+func runMigrations(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		if err := applyMigration(db, version, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", version, migrations[i].name, err)
+		}
+		log.Printf("🗄️  Applied migration %d: %s", version, migrations[i].name)
+	}
+	return nil
+}
+
+// applyMigration runs one step and records its version in the same transaction
+func applyMigration(db *sql.DB, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name) VALUES (?, ?)`, version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -2,20 +2,25 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 )
 
 type Playlist struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Videos      []string  `json:"videos"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Type        string         `json:"type"`
+	Rules       *PlaylistRules `json:"rules,omitempty"`
+	CoverVideo  string         `json:"coverVideo,omitempty"` // Video whose thumbnail is the cover, empty for a montage
+	Videos      []string       `json:"videos"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
 }
 
 type PlaylistStorage struct {
-	db *sql.DB
+	db         *sql.DB
+	durationOf func(path string) time.Duration
 }
 
 func NewPlaylistStorage(dataDir string) *PlaylistStorage {
@@ -26,41 +31,61 @@ func NewPlaylistStorage(dataDir string) *PlaylistStorage {
 	return &PlaylistStorage{db: db}
 }
 
+// NewPlaylistStorageWithDB creates a PlaylistStorage on an already opened database
+func NewPlaylistStorageWithDB(db *sql.DB) *PlaylistStorage {
+	return &PlaylistStorage{db: db}
+}
+
 func generateID() string {
 	return time.Now().Format("20060102150405")
 }
 
 func (s *PlaylistStorage) Create(name, description string) *Playlist {
+	return s.create(name, description, PlaylistStatic, nil, "")
+}
+
+// CreateSmart creates a playlist whose videos are selected by rules
+// The returned videos are the ones matching for username.
+func (s *PlaylistStorage) CreateSmart(name, description string, rules *PlaylistRules, username string) *Playlist {
+	return s.create(name, description, PlaylistSmart, rules, username)
+}
+
+func (s *PlaylistStorage) create(name, description, playlistType string, rules *PlaylistRules, username string) *Playlist {
 	id := generateID()
 	now := time.Now()
 
 	_, err := s.db.Exec(`
-		INSERT INTO playlists (id, name, description, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, id, name, description, now, now)
+		INSERT INTO playlists (id, name, description, type, rules, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, name, description, playlistType, encodeRules(rules), now, now)
 
 	if err != nil {
 		return nil
 	}
 
-	return &Playlist{
+	playlist := &Playlist{
 		ID:          id,
 		Name:        name,
 		Description: description,
-		Videos:      []string{},
+		Type:        playlistType,
+		Rules:       rules,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
+	playlist.Videos = s.resolveVideos(playlist, username)
+	return playlist
 }
 
-func (s *PlaylistStorage) Get(id string) *Playlist {
+// Get returns a playlist, with the videos of a smart playlist as they match for username
+func (s *PlaylistStorage) Get(id, username string) *Playlist {
 	var playlist Playlist
+	var rules string
 	var createdAt, updatedAt sql.NullTime
 
 	err := s.db.QueryRow(`
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, type, rules, cover_video, created_at, updated_at
 		FROM playlists WHERE id = ?
-	`, id).Scan(&playlist.ID, &playlist.Name, &playlist.Description, &createdAt, &updatedAt)
+	`, id).Scan(&playlist.ID, &playlist.Name, &playlist.Description, &playlist.Type, &rules, &playlist.CoverVideo, &createdAt, &updatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil
@@ -76,10 +101,29 @@ func (s *PlaylistStorage) Get(id string) *Playlist {
 		playlist.UpdatedAt = updatedAt.Time
 	}
 
-	playlist.Videos = s.getVideos(id)
+	playlist.Rules = decodeRules(rules)
+	playlist.Videos = s.resolveVideos(&playlist, username)
 	return &playlist
 }
 
+// resolveVideos returns the videos of a playlist, evaluating the rules of smart playlists for username
+func (s *PlaylistStorage) resolveVideos(playlist *Playlist, username string) []string {
+	if playlist.Type == PlaylistSmart {
+		if playlist.Rules == nil {
+			return []string{}
+		}
+		return s.evaluateRules(playlist.Rules, username)
+	}
+	return s.getVideos(playlist.ID)
+}
+
+// Type returns the type of a playlist, or "" if it doesn't exist
+func (s *PlaylistStorage) Type(id string) string {
+	var playlistType string
+	s.db.QueryRow(`SELECT type FROM playlists WHERE id = ?`, id).Scan(&playlistType)
+	return playlistType
+}
+
 func (s *PlaylistStorage) getVideos(playlistID string) []string {
 	rows, err := s.db.Query(`
 		SELECT video_path FROM playlist_videos
@@ -105,10 +149,11 @@ func (s *PlaylistStorage) getVideos(playlistID string) []string {
 	return videos
 }
 
-func (s *PlaylistStorage) GetAll() []*Playlist {
+// GetAll returns every playlist, with the videos of smart playlists as they match for username
+func (s *PlaylistStorage) GetAll(username string) []*Playlist {
 	rows, err := s.db.Query(`
-		SELECT id, name, description, created_at, updated_at
-		FROM playlists ORDER BY updated_at DESC
+		SELECT id, name, description, type, rules, cover_video, created_at, updated_at
+		FROM playlists ORDER BY updated_at DESC, id
 	`)
 	if err != nil {
 		return []*Playlist{}
@@ -118,9 +163,10 @@ func (s *PlaylistStorage) GetAll() []*Playlist {
 	var playlists []*Playlist
 	for rows.Next() {
 		var p Playlist
+		var rules string
 		var createdAt, updatedAt sql.NullTime
 
-		err := rows.Scan(&p.ID, &p.Name, &p.Description, &createdAt, &updatedAt)
+		err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Type, &rules, &p.CoverVideo, &createdAt, &updatedAt)
 		if err != nil {
 			continue
 		}
@@ -132,17 +178,23 @@ func (s *PlaylistStorage) GetAll() []*Playlist {
 			p.UpdatedAt = updatedAt.Time
 		}
 
-		p.Videos = s.getVideos(p.ID)
+		p.Rules = decodeRules(rules)
 		playlists = append(playlists, &p)
 	}
 
+	rows.Close()
+
+	for _, p := range playlists {
+		p.Videos = s.resolveVideos(p, username)
+	}
+
 	if playlists == nil {
 		playlists = []*Playlist{}
 	}
 	return playlists
 }
 
-func (s *PlaylistStorage) Update(id, name, description string) *Playlist {
+func (s *PlaylistStorage) Update(id, name, description, username string) *Playlist {
 	now := time.Now()
 
 	if name != "" && description != "" {
@@ -168,34 +220,91 @@ func (s *PlaylistStorage) Update(id, name, description string) *Playlist {
 		}
 	}
 
-	return s.Get(id)
+	return s.Get(id, username)
+}
+
+// UpdateRules replaces the rules of a smart playlist
+func (s *PlaylistStorage) UpdateRules(id string, rules *PlaylistRules, username string) *Playlist {
+	_, err := s.db.Exec(`
+		UPDATE playlists SET rules = ?, updated_at = ? WHERE id = ? AND type = ?
+	`, encodeRules(rules), time.Now(), id, PlaylistSmart)
+	if err != nil {
+		return nil
+	}
+	return s.Get(id, username)
+}
+
+// SetCover sets the video whose thumbnail is the playlist cover, an empty path restores the montage
+func (s *PlaylistStorage) SetCover(id, videoPath string) bool {
+	result, err := s.db.Exec(`
+		UPDATE playlists SET cover_video = ?, updated_at = ? WHERE id = ?
+	`, videoPath, time.Now(), id)
+	if err != nil {
+		return false
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0
 }
 
 func (s *PlaylistStorage) Delete(id string) bool {
 	_, err := s.db.Exec(`DELETE FROM playlists WHERE id = ?`, id)
+	if err == nil {
+		s.db.Exec(`DELETE FROM playlist_shares WHERE playlist_id = ?`, id)
+	}
 	return err == nil
 }
 
+// ErrPlaylistNotFound is returned when a playlist doesn't exist
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
 func (s *PlaylistStorage) AddVideo(playlistID, videoPath string) bool {
+	_, _, err := s.AddVideos(playlistID, []string{videoPath})
+	return err == nil
+}
+
+// AddVideos appends videos to a playlist in one transaction
+// Videos already in the playlist (or repeated in the list) are skipped
+func (s *PlaylistStorage) AddVideos(playlistID string, videoPaths []string) (added, skipped int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
 	var maxPos int
-	err := s.db.QueryRow(`
-		SELECT COALESCE(MAX(position), -1) FROM playlist_videos WHERE playlist_id = ?
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(pv.position), -1) FROM playlists p
+		LEFT JOIN playlist_videos pv ON pv.playlist_id = p.id
+		WHERE p.id = ? GROUP BY p.id
 	`, playlistID).Scan(&maxPos)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrPlaylistNotFound
+	}
 	if err != nil {
-		return false
+		return 0, 0, err
 	}
 
-	_, err = s.db.Exec(`
-		INSERT OR IGNORE INTO playlist_videos (playlist_id, video_path, position, added_at)
-		VALUES (?, ?, ?, ?)
-	`, playlistID, videoPath, maxPos+1, time.Now())
-
-	if err != nil {
-		return false
+	now := time.Now()
+	for _, videoPath := range videoPaths {
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO playlist_videos (playlist_id, video_path, position, added_at)
+			VALUES (?, ?, ?, ?)
+		`, playlistID, videoPath, maxPos+1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			skipped++
+			continue
+		}
+		maxPos++
+		added++
 	}
 
-	s.db.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID)
-	return true
+	if _, err := tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, now, playlistID); err != nil {
+		return 0, 0, err
+	}
+	return added, skipped, tx.Commit()
 }
 
 func (s *PlaylistStorage) RemoveVideo(playlistID, videoPath string) bool {
@@ -211,6 +320,43 @@ func (s *PlaylistStorage) RemoveVideo(playlistID, videoPath string) bool {
 	return true
 }
 
+// RemoveVideos removes videos from a playlist in one transaction
+// Videos that aren't in the playlist are skipped
+func (s *PlaylistStorage) RemoveVideos(playlistID string, videoPaths []string) (removed, skipped int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow(`SELECT 1 FROM playlists WHERE id = ?`, playlistID).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, ErrPlaylistNotFound
+		}
+		return 0, 0, err
+	}
+
+	for _, videoPath := range videoPaths {
+		result, err := tx.Exec(`
+			DELETE FROM playlist_videos WHERE playlist_id = ? AND video_path = ?
+		`, playlistID, videoPath)
+		if err != nil {
+			return 0, 0, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			skipped++
+			continue
+		}
+		removed++
+	}
+
+	if _, err := tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID); err != nil {
+		return 0, 0, err
+	}
+	return removed, skipped, tx.Commit()
+}
+
 func (s *PlaylistStorage) ReorderVideos(playlistID string, videoPaths []string) bool {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Playlist types
+const (
+	PlaylistStatic = "static" // Videos are added by hand
+	PlaylistSmart  = "smart"  // Videos are selected by rules
+)
+
+// PlaylistRules select the videos of a smart playlist
+// Zero values don't filter
+type PlaylistRules struct {
+	Tags             []string `json:"tags,omitempty"`
+	TagMode          string   `json:"tagMode,omitempty"` // "all" (default) or "any"
+	MinViews         int      `json:"minViews,omitempty"`
+	Liked            *bool    `json:"liked,omitempty"` // Liked by the user viewing the playlist
+	ViewedWithinDays int      `json:"viewedWithinDays,omitempty"`
+	DurationMin      float64  `json:"durationMin,omitempty"` // Minutes
+	DurationMax      float64  `json:"durationMax,omitempty"` // Minutes
+	Dir              *int     `json:"dir,omitempty"`         // Index into VIDEO_DIR
+	Sort             string   `json:"sort,omitempty"`        // hotness (default), views, likes, lastViewed, name
+	Order            string   `json:"order,omitempty"`       // desc (default) or asc
+	Limit            int      `json:"limit,omitempty"`
+}
+
+// ruleSortColumns maps rule sort keys to video_stats columns
+var ruleSortColumns = map[string]string{
+	"hotness":    "v.hotness",
+	"views":      "v.views",
+	"likes":      "v.likes",
+	"lastViewed": "v.last_viewed",
+	"name":       "v.name",
+}
+
+// Validate checks the rules and fills in defaults
+func (r *PlaylistRules) Validate() error {
+	if r.TagMode == "" {
+		r.TagMode = "all"
+	}
+	if r.TagMode != "all" && r.TagMode != "any" {
+		return fmt.Errorf("invalid tagMode %q", r.TagMode)
+	}
+	if r.Sort == "" {
+		r.Sort = "hotness"
+	}
+	if _, ok := ruleSortColumns[r.Sort]; !ok {
+		return fmt.Errorf("invalid sort %q", r.Sort)
+	}
+	if r.Order == "" {
+		r.Order = "desc"
+	}
+	if r.Order != "asc" && r.Order != "desc" {
+		return fmt.Errorf("invalid order %q", r.Order)
+	}
+	if r.MinViews < 0 || r.ViewedWithinDays < 0 || r.Limit < 0 || r.DurationMin < 0 || r.DurationMax < 0 {
+		return fmt.Errorf("rule values can't be negative")
+	}
+	if r.Dir != nil && *r.Dir < 0 {
+		return fmt.Errorf("invalid dir %d", *r.Dir)
+	}
+
+	tags := r.Tags[:0]
+	for _, tag := range r.Tags {
+		if tag = NormalizeTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	r.Tags = tags
+	return nil
+}
+
+// SetDurationLookup sets how smart playlists find video durations, which aren't stored in the database
+func (s *PlaylistStorage) SetDurationLookup(lookup func(path string) time.Duration) {
+	s.durationOf = lookup
+}
+
+// evaluateRules returns the video paths matching smart playlist rules for a user
+// Likes are per user, so the same playlist can hold different videos for each.
+func (s *PlaylistStorage) evaluateRules(rules *PlaylistRules, username string) []string {
+	// Tagged videos may not have stats yet
+	query := `
+		SELECT v.path FROM (
+			SELECT path, name, views, likes, hotness, last_viewed FROM video_stats
+			UNION ALL
+			SELECT DISTINCT video_path, '', 0, 0, 0, NULL FROM video_tags
+			WHERE video_path NOT IN (SELECT path FROM video_stats)
+		) v WHERE 1 = 1`
+	var args []any
+
+	if rules.MinViews > 0 {
+		query += ` AND v.views >= ?`
+		args = append(args, rules.MinViews)
+	}
+	if rules.Liked != nil {
+		const likedByUser = `EXISTS (
+			SELECT 1 FROM user_video_stats u
+			WHERE u.path = v.path AND u.username = ? AND u.liked = 1)`
+		if *rules.Liked {
+			query += ` AND ` + likedByUser
+		} else {
+			query += ` AND NOT ` + likedByUser
+		}
+		args = append(args, username)
+	}
+	if rules.ViewedWithinDays > 0 {
+		query += ` AND v.last_viewed >= ?`
+		args = append(args, time.Now().AddDate(0, 0, -rules.ViewedWithinDays))
+	}
+	if rules.Dir != nil {
+		query += ` AND v.path LIKE ?`
+		args = append(args, fmt.Sprintf("%d:%%", *rules.Dir))
+	}
+
+	const hasTag = ` AND EXISTS (
+		SELECT 1 FROM video_tags vt JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_path = v.path AND t.name %s)`
+	if len(rules.Tags) > 0 {
+		if rules.TagMode == "any" {
+			query += fmt.Sprintf(hasTag, "IN (?"+strings.Repeat(", ?", len(rules.Tags)-1)+")")
+			for _, tag := range rules.Tags {
+				args = append(args, tag)
+			}
+		} else {
+			for _, tag := range rules.Tags {
+				query += fmt.Sprintf(hasTag, "= ?")
+				args = append(args, tag)
+			}
+		}
+	}
+
+	query += fmt.Sprintf(` ORDER BY %s %s, v.path`, ruleSortColumns[rules.Sort], strings.ToUpper(rules.Order))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return []string{}
+	}
+	defer rows.Close()
+
+	filterDuration := s.durationOf != nil && (rules.DurationMin > 0 || rules.DurationMax > 0)
+	videos := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			continue
+		}
+		if filterDuration {
+			minutes := s.durationOf(path).Minutes()
+			if rules.DurationMin > 0 && minutes < rules.DurationMin {
+				continue
+			}
+			if rules.DurationMax > 0 && minutes > rules.DurationMax {
+				continue
+			}
+		}
+		videos = append(videos, path)
+		if rules.Limit > 0 && len(videos) >= rules.Limit {
+			break
+		}
+	}
+	return videos
+}
+
+// encodeRules serializes rules for the playlists.rules column
+func encodeRules(rules *PlaylistRules) string {
+	if rules == nil {
+		return ""
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeRules parses the playlists.rules column
+func decodeRules(data string) *PlaylistRules {
+	if data == "" {
+		return nil
+	}
+	var rules PlaylistRules
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil
+	}
+	return &rules
+}
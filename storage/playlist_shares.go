@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// PlaylistShare is a read-only link to a playlist
+type PlaylistShare struct {
+	ID         string     `json:"id"`
+	PlaylistID string     `json:"playlistId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreateShare records a new share of a playlist, expiresAt may be nil for no expiry
+func (s *PlaylistStorage) CreateShare(playlistID string, expiresAt *time.Time) *PlaylistShare {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil
+	}
+
+	share := &PlaylistShare{
+		ID:         hex.EncodeToString(b),
+		PlaylistID: playlistID,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO playlist_shares (id, playlist_id, created_at, expires_at)
+		SELECT ?, id, ?, ? FROM playlists WHERE id = ?
+	`, share.ID, share.CreatedAt, expiresAt, playlistID)
+	if err != nil || !s.ShareActive(share.ID, playlistID) {
+		return nil
+	}
+	return share
+}
+
+// ListShares returns the shares of a playlist, newest first
+func (s *PlaylistStorage) ListShares(playlistID string) []PlaylistShare {
+	shares := []PlaylistShare{}
+
+	rows, err := s.db.Query(`
+		SELECT id, playlist_id, created_at, expires_at, revoked_at
+		FROM playlist_shares WHERE playlist_id = ? ORDER BY created_at DESC
+	`, playlistID)
+	if err != nil {
+		return shares
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var share PlaylistShare
+		var createdAt, expiresAt, revokedAt sql.NullTime
+		if err := rows.Scan(&share.ID, &share.PlaylistID, &createdAt, &expiresAt, &revokedAt); err != nil {
+			continue
+		}
+		if createdAt.Valid {
+			share.CreatedAt = createdAt.Time
+		}
+		if expiresAt.Valid {
+			share.ExpiresAt = &expiresAt.Time
+		}
+		if revokedAt.Valid {
+			share.RevokedAt = &revokedAt.Time
+		}
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// RevokeShare disables a share, returns false if it doesn't exist or was already revoked
+func (s *PlaylistStorage) RevokeShare(playlistID, shareID string) bool {
+	result, err := s.db.Exec(`
+		UPDATE playlist_shares SET revoked_at = ?
+		WHERE id = ? AND playlist_id = ? AND revoked_at IS NULL
+	`, time.Now(), shareID, playlistID)
+	if err != nil {
+		return false
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0
+}
+
+// ShareActive reports whether a share exists for the playlist and is neither revoked nor expired
+func (s *PlaylistStorage) ShareActive(shareID, playlistID string) bool {
+	var expiresAt, revokedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT expires_at, revoked_at FROM playlist_shares WHERE id = ? AND playlist_id = ?
+	`, shareID, playlistID).Scan(&expiresAt, &revokedAt)
+	if err != nil || revokedAt.Valid {
+		return false
+	}
+	return !expiresAt.Valid || time.Now().Before(expiresAt.Time)
+}
+
+// HasVideo reports whether a video belongs to a playlist, as seen by username
+func (s *PlaylistStorage) HasVideo(playlistID, videoPath, username string) bool {
+	if s.Type(playlistID) == PlaylistSmart {
+		playlist := s.Get(playlistID, username)
+		if playlist == nil {
+			return false
+		}
+		for _, path := range playlist.Videos {
+			if path == videoPath {
+				return true
+			}
+		}
+		return false
+	}
+
+	var exists int
+	err := s.db.QueryRow(`
+		SELECT 1 FROM playlist_videos WHERE playlist_id = ? AND video_path = ?
+	`, playlistID, videoPath).Scan(&exists)
+	return err == nil
+}
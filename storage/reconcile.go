@@ -0,0 +1,141 @@
+package storage
+
+import "time"
+
+// ReconcileStats records the content hash of every current video and relinks stats
+// of videos that were renamed or moved
+// current maps each prefixed path on disk to its content hash. A stats row whose path
+// no longer exists is moved to the current path with the same content hash, as long as
+// exactly one such path exists and it has no stats of its own. Tags and playlist entries
+// follow the stats row. Rows from before content hashes were tracked fall back to the
+// thumbnail hash, which is the same hash.
+// Thumbnail and preview hashes that no longer match the current content hash are
+// cleared, so the cache is regenerated for files that changed or whose hash used
+// to collide with another file's.
+// Returns the number of rows relinked
+func (s *Storage) ReconcileStats(current map[string]string) int {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0
+	}
+	defer tx.Rollback()
+
+	// Existing rows and their identity
+	rows, err := tx.Query(`SELECT path, COALESCE(NULLIF(content_hash, ''), thumbnail_hash, '') FROM video_stats`)
+	if err != nil {
+		return 0
+	}
+	existing := make(map[string]string)
+	for rows.Next() {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err == nil {
+			existing[path] = hash
+		}
+	}
+	rows.Close()
+
+	// Current paths without a stats row, grouped by hash
+	unclaimed := make(map[string][]string)
+	for path, hash := range current {
+		if _, ok := existing[path]; !ok && hash != "" {
+			unclaimed[hash] = append(unclaimed[hash], path)
+		}
+	}
+
+	relinked := 0
+	for oldPath, hash := range existing {
+		if _, ok := current[oldPath]; ok || hash == "" {
+			continue
+		}
+		candidates := unclaimed[hash]
+		if len(candidates) != 1 {
+			continue // Gone for good, or ambiguous between duplicates
+		}
+		newPath := candidates[0]
+
+		if _, err := tx.Exec(`UPDATE video_stats SET path = ?, updated_at = CURRENT_TIMESTAMP WHERE path = ?`, newPath, oldPath); err != nil {
+			continue
+		}
+		tx.Exec(`UPDATE OR IGNORE user_video_stats SET path = ? WHERE path = ?`, newPath, oldPath)
+		tx.Exec(`UPDATE view_history SET path = ? WHERE path = ?`, newPath, oldPath)
+		tx.Exec(`UPDATE OR IGNORE video_tags SET video_path = ? WHERE video_path = ?`, newPath, oldPath)
+		tx.Exec(`UPDATE OR IGNORE playlist_videos SET video_path = ? WHERE video_path = ?`, newPath, oldPath)
+
+		delete(unclaimed, hash)
+		relinked++
+	}
+
+	// Remember the identity of every current video for future moves, and forget
+	// cache files generated from other content
+	for path, hash := range current {
+		if hash != "" {
+			tx.Exec(`
+				UPDATE video_stats SET
+					content_hash = ?,
+					thumbnail_hash = CASE WHEN thumbnail_hash = ? THEN thumbnail_hash END,
+					preview_hash = CASE WHEN preview_hash = ? THEN preview_hash END
+				WHERE path = ? AND (COALESCE(content_hash, '') != ? OR thumbnail_hash != ? OR preview_hash != ?)
+			`, hash, hash, hash, path, hash, hash, hash)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0
+	}
+	return relinked
+}
+
+// SeenVideo is a video on disk as passed to RecordFirstSeen
+type SeenVideo struct {
+	Path    string
+	Name    string
+	ModTime time.Time
+}
+
+// RecordFirstSeen stamps first_seen on videos that don't have it yet, creating
+// their stats row if needed
+// New videos get the current time. The first time it runs, on a new library or
+// right after upgrading, every video gets its modification time instead, so the
+// whole existing library doesn't look newly added.
+// Call it after ReconcileStats, a moved video must keep its row and timestamp.
+// It runs on every index refresh, so videos already stamped are skipped without
+// a statement each.
+func (s *Storage) RecordFirstSeen(videos []SeenVideo) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT path FROM video_stats WHERE first_seen IS NOT NULL`)
+	if err != nil {
+		return
+	}
+	stamped := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if rows.Scan(&path) == nil {
+			stamped[path] = true
+		}
+	}
+	rows.Close()
+	recorded := len(stamped) > 0
+
+	now := time.Now()
+	for _, v := range videos {
+		if stamped[v.Path] {
+			continue
+		}
+		seen := now
+		if !recorded {
+			seen = v.ModTime
+		}
+		tx.Exec(`
+			INSERT INTO video_stats (path, name, first_seen, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(path) DO UPDATE SET first_seen = excluded.first_seen
+			WHERE video_stats.first_seen IS NULL
+		`, v.Path, v.Name, seen)
+	}
+	tx.Commit()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	db, err := OpenDB(t.TempDir(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewStorageWithDB(db)
+}
+
+// TestReconcileClearsCollidedHashes covers episodes from the same encoder whose
+// first megabyte is identical, which used to get one fast hash and so share the
+// first episode's thumbnail and preview
+func TestReconcileClearsCollidedHashes(t *testing.T) {
+	dir := t.TempDir()
+	header := bytes.Repeat([]byte{0x42}, maxHashReadSize)
+	ep1 := filepath.Join(dir, "ep1.mp4")
+	ep2 := filepath.Join(dir, "ep2.mp4")
+	if err := os.WriteFile(ep1, append(header, "episode one"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ep2, append(header, "the second episode"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash1, err := GetFileContentHash(ep1, HashModeFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := GetFileContentHash(ep2, HashModeFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash1 == hash2 {
+		t.Fatalf("files sharing their first 1MB got the same fast hash %s", hash1)
+	}
+
+	// Both rows point at the first episode's cache, as recorded by older releases
+	s := newTestStorage(t)
+	for _, path := range []string{"0:ep1.mp4", "0:ep2.mp4"} {
+		s.SetThumbnailHash(path, filepath.Base(path), hash1)
+		s.SetPreviewHash(path, filepath.Base(path), hash1)
+	}
+
+	s.ReconcileStats(map[string]string{"0:ep1.mp4": hash1, "0:ep2.mp4": hash2})
+
+	if got := s.GetThumbnailHash("0:ep1.mp4"); got != hash1 {
+		t.Errorf("ep1 thumbnail hash = %q, want it kept as %q", got, hash1)
+	}
+	if got := s.GetPreviewHash("0:ep1.mp4"); got != hash1 {
+		t.Errorf("ep1 preview hash = %q, want it kept as %q", got, hash1)
+	}
+	if got := s.GetThumbnailHash("0:ep2.mp4"); got != "" {
+		t.Errorf("ep2 thumbnail hash = %q, want it cleared so it's regenerated", got)
+	}
+	if got := s.GetPreviewHash("0:ep2.mp4"); got != "" {
+		t.Errorf("ep2 preview hash = %q, want it cleared so it's regenerated", got)
+	}
+}
+
+func TestRecordFirstSeen(t *testing.T) {
+	s := newTestStorage(t)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// The first run stamps the existing library with modification times
+	s.RecordFirstSeen([]SeenVideo{{Path: "0:old.mp4", Name: "old.mp4", ModTime: modTime}})
+	if got := s.GetStats("0:old.mp4", "").FirstSeen; !got.Equal(modTime) {
+		t.Fatalf("old.mp4 first seen = %s, want its modification time %s", got, modTime)
+	}
+
+	before := time.Now().Add(-time.Second)
+	s.RecordFirstSeen([]SeenVideo{
+		{Path: "0:old.mp4", Name: "old.mp4", ModTime: modTime.Add(time.Hour)},
+		{Path: "0:new.mp4", Name: "new.mp4", ModTime: modTime},
+	})
+	if got := s.GetStats("0:old.mp4", "").FirstSeen; !got.Equal(modTime) {
+		t.Errorf("old.mp4 first seen = %s, want it kept as %s", got, modTime)
+	}
+	if got := s.GetStats("0:new.mp4", "").FirstSeen; got.Before(before) {
+		t.Errorf("new.mp4 first seen = %s, want the time it was found", got)
+	}
+}
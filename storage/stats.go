@@ -3,22 +3,67 @@ package storage
 import (
 	"database/sql"
 	"time"
+
+	"github.com/kitsnail/streamlet/config"
 )
 
+// VideoStats holds the stats of a video as seen by one user
+// Views, Likes, LastViewed and Hotness are totals across all users;
+// Liked, Bookmarked, UserViews, PositionSec, WatchSeconds and Completed belong to the requesting user
 type VideoStats struct {
-	Path          string    `json:"path"`
-	Name          string    `json:"name"`
-	Views         int       `json:"views"`
-	Likes         int       `json:"likes"`
-	Liked         bool      `json:"liked"`
-	LastViewed    time.Time `json:"lastViewed"`
-	Hotness       float64   `json:"hotness"`
-	ThumbnailHash string    `json:"thumbnailHash"`
-	PreviewHash   string    `json:"previewHash"`
+	Path            string    `json:"path"`
+	Name            string    `json:"name"`
+	Views           int       `json:"views"`
+	Likes           int       `json:"likes"`
+	Liked           bool      `json:"liked"`
+	Bookmarked      bool      `json:"bookmarked"` // Private "watch later" flag, independent of likes
+	LastViewed      time.Time `json:"lastViewed"`
+	Hotness         float64   `json:"hotness"`
+	ThumbnailHash   string    `json:"thumbnailHash"`
+	PreviewHash     string    `json:"previewHash"`
+	CustomThumbnail string    `json:"customThumbnail"` // Content hash of an uploaded poster, empty if none
+	UserViews       int       `json:"userViews"`       // Views by the requesting user
+	PositionSec     float64   `json:"positionSec"`     // Last playback position for resume
+	WatchSeconds    float64   `json:"watchSeconds"`    // Total time actually spent watching
+	Completed       bool      `json:"completed"`       // Whether the video was ever watched to the end
+	FirstSeen       time.Time `json:"firstSeen"`       // When the scanner first found the file, zero if not recorded yet
+}
+
+// statsColumns selects a VideoStats row, joining the user's own stats (bound as the first parameter)
+const statsColumns = `
+	SELECT v.path, v.name, v.views, v.likes, COALESCE(u.liked, 0), COALESCE(u.bookmarked, 0), v.last_viewed, v.hotness,
+		COALESCE(u.views, 0), COALESCE(u.position_sec, 0), COALESCE(u.watch_seconds, 0), COALESCE(u.completed, 0),
+		v.first_seen, COALESCE(v.thumbnail_hash, ''), COALESCE(v.preview_hash, ''), COALESCE(v.custom_thumbnail, '')
+	FROM video_stats v
+	LEFT JOIN user_video_stats u ON u.path = v.path AND u.username = ?
+`
+
+// scanStats scans a row selected with statsColumns
+func scanStats(row interface{ Scan(...any) error }) (*VideoStats, error) {
+	var stats VideoStats
+	var lastViewed, firstSeen sql.NullTime
+	var name sql.NullString
+
+	err := row.Scan(&stats.Path, &name, &stats.Views, &stats.Likes, &stats.Liked, &stats.Bookmarked, &lastViewed, &stats.Hotness,
+		&stats.UserViews, &stats.PositionSec, &stats.WatchSeconds, &stats.Completed, &firstSeen,
+		&stats.ThumbnailHash, &stats.PreviewHash, &stats.CustomThumbnail)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Name = name.String
+	if lastViewed.Valid {
+		stats.LastViewed = lastViewed.Time
+	}
+	if firstSeen.Valid {
+		stats.FirstSeen = firstSeen.Time
+	}
+	return &stats, nil
 }
 
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	hotness config.HotnessConfig
 }
 
 func NewStorage(dataDir string) *Storage {
@@ -26,29 +71,29 @@ func NewStorage(dataDir string) *Storage {
 	if err != nil {
 		panic(err)
 	}
-	return &Storage{db: db}
+	return &Storage{db: db, hotness: defaultHotness}
 }
 
-func (s *Storage) GetStats(path string) *VideoStats {
-	var stats VideoStats
-	var lastViewed sql.NullTime
-	var name sql.NullString
+// NewStorageWithDB creates a Storage on an already opened database
+func NewStorageWithDB(db *sql.DB) *Storage {
+	return &Storage{db: db, hotness: defaultHotness}
+}
 
-	err := s.db.QueryRow(`
-		SELECT path, name, views, likes, liked, last_viewed, hotness
-		FROM video_stats WHERE path = ?
-	`, path).Scan(&stats.Path, &name, &stats.Views, &stats.Likes, &stats.Liked, &lastViewed, &stats.Hotness)
+// defaultHotness matches the original fixed weights, used until SetHotnessConfig is called
+var defaultHotness = config.HotnessConfig{
+	ViewWeight:   1.0,
+	LikeWeight:   5.0,
+	RecencyBonus: 70.0,
+	HalfLife:     72 * time.Hour,
+}
 
-	if err == sql.ErrNoRows {
-		return &VideoStats{
-			Path:    path,
-			Views:   0,
-			Likes:   0,
-			Liked:   false,
-			Hotness: 0,
-		}
-	}
+// SetHotnessConfig sets the weights used by the hotness formula
+func (s *Storage) SetHotnessConfig(hc config.HotnessConfig) {
+	s.hotness = hc
+}
 
+func (s *Storage) GetStats(path, username string) *VideoStats {
+	stats, err := scanStats(s.db.QueryRow(statsColumns+`WHERE v.path = ?`, username, path))
 	if err != nil {
 		return &VideoStats{
 			Path:    path,
@@ -58,20 +103,11 @@ func (s *Storage) GetStats(path string) *VideoStats {
 			Hotness: 0,
 		}
 	}
-
-	stats.Name = name.String
-	if lastViewed.Valid {
-		stats.LastViewed = lastViewed.Time
-	}
-
-	return &stats
+	return stats
 }
 
-func (s *Storage) GetAllStats() map[string]*VideoStats {
-	rows, err := s.db.Query(`
-		SELECT path, name, views, likes, liked, last_viewed, hotness
-		FROM video_stats
-	`)
+func (s *Storage) GetAllStats(username string) map[string]*VideoStats {
+	rows, err := s.db.Query(statsColumns, username)
 	if err != nil {
 		return make(map[string]*VideoStats)
 	}
@@ -79,26 +115,27 @@ func (s *Storage) GetAllStats() map[string]*VideoStats {
 
 	result := make(map[string]*VideoStats)
 	for rows.Next() {
-		var stats VideoStats
-		var lastViewed sql.NullTime
-		var name sql.NullString
-
-		err := rows.Scan(&stats.Path, &name, &stats.Views, &stats.Likes, &stats.Liked, &lastViewed, &stats.Hotness)
+		stats, err := scanStats(rows)
 		if err != nil {
 			continue
 		}
-
-		stats.Name = name.String
-		if lastViewed.Valid {
-			stats.LastViewed = lastViewed.Time
-		}
-		result[stats.Path] = &stats
+		result[stats.Path] = stats
 	}
 
 	return result
 }
 
-func (s *Storage) IncrementViews(path, name string) {
+// ensureStats creates the stats row of a video if it doesn't exist yet
+func (s *Storage) ensureStats(path, name string) {
+	s.db.Exec(`
+		INSERT INTO video_stats (path, name, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			name = COALESCE(NULLIF(?, ''), name)
+	`, path, name, name)
+}
+
+func (s *Storage) IncrementViews(path, name, username string) {
 	now := time.Now()
 	_, err := s.db.Exec(`
 		INSERT INTO video_stats (path, name, views, last_viewed, updated_at)
@@ -114,12 +151,31 @@ func (s *Storage) IncrementViews(path, name string) {
 		return
 	}
 
+	s.db.Exec(`
+		INSERT INTO user_video_stats (username, path, views, last_viewed, updated_at)
+		VALUES (?, ?, 1, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			views = views + 1,
+			last_viewed = ?,
+			updated_at = CURRENT_TIMESTAMP
+	`, username, path, now, now)
+
+	s.db.Exec(`INSERT INTO view_history (username, path, viewed_at) VALUES (?, ?, ?)`, username, path, now)
+
 	s.updateHotness(path)
 }
 
-func (s *Storage) ToggleLike(path, name string) bool {
+// DeleteStats removes the stats row for a video path
+func (s *Storage) DeleteStats(path string) {
+	s.db.Exec(`DELETE FROM video_stats WHERE path = ?`, path)
+	s.db.Exec(`DELETE FROM user_video_stats WHERE path = ?`, path)
+	s.db.Exec(`DELETE FROM view_history WHERE path = ?`, path)
+}
+
+// ToggleLike flips whether the user likes a video and adjusts the total like count
+func (s *Storage) ToggleLike(path, name, username string) bool {
 	var liked bool
-	err := s.db.QueryRow(`SELECT liked FROM video_stats WHERE path = ?`, path).Scan(&liked)
+	err := s.db.QueryRow(`SELECT liked FROM user_video_stats WHERE username = ? AND path = ?`, username, path).Scan(&liked)
 	if err == sql.ErrNoRows {
 		liked = false
 	} else if err != nil {
@@ -128,62 +184,111 @@ func (s *Storage) ToggleLike(path, name string) bool {
 
 	newLiked := !liked
 
-	if liked {
-		_, err = s.db.Exec(`
-			INSERT INTO video_stats (path, name, likes, liked, updated_at)
-			VALUES (?, ?, 0, 0, CURRENT_TIMESTAMP)
-			ON CONFLICT(path) DO UPDATE SET
-				likes = likes - 1,
-				liked = 0,
-				name = COALESCE(NULLIF(?, ''), name),
-				updated_at = CURRENT_TIMESTAMP
-		`, path, name, name)
-	} else {
-		_, err = s.db.Exec(`
-			INSERT INTO video_stats (path, name, likes, liked, updated_at)
-			VALUES (?, ?, 1, 1, CURRENT_TIMESTAMP)
-			ON CONFLICT(path) DO UPDATE SET
-				likes = likes + 1,
-				liked = 1,
-				name = COALESCE(NULLIF(?, ''), name),
-				updated_at = CURRENT_TIMESTAMP
-		`, path, name, name)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return liked
 	}
+	defer tx.Rollback()
 
+	_, err = tx.Exec(`
+		INSERT INTO user_video_stats (username, path, liked, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			liked = ?,
+			updated_at = CURRENT_TIMESTAMP
+	`, username, path, newLiked, newLiked)
 	if err != nil {
-		return false
+		return liked
+	}
+
+	delta := 1
+	if liked {
+		delta = -1
+	}
+	_, err = tx.Exec(`
+		INSERT INTO video_stats (path, name, likes, updated_at)
+		VALUES (?, ?, MAX(?, 0), CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			likes = MAX(likes + ?, 0),
+			name = COALESCE(NULLIF(?, ''), name),
+			updated_at = CURRENT_TIMESTAMP
+	`, path, name, delta, delta, name)
+	if err != nil {
+		return liked
+	}
+
+	if err := tx.Commit(); err != nil {
+		return liked
 	}
 
 	s.updateHotness(path)
 	return newLiked
 }
 
+// ToggleBookmark flips whether the user bookmarked a video, returning the new state
+// Bookmarks are private to the user and, unlike likes, don't count toward hotness
+func (s *Storage) ToggleBookmark(path, name, username string) bool {
+	s.ensureStats(path, name)
+
+	var bookmarked bool
+	err := s.db.QueryRow(`
+		INSERT INTO user_video_stats (username, path, bookmarked, updated_at)
+		VALUES (?, ?, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			bookmarked = 1 - bookmarked,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING bookmarked
+	`, username, path).Scan(&bookmarked)
+	if err != nil {
+		return false
+	}
+	return bookmarked
+}
+
 func (s *Storage) updateHotness(path string) {
 	var views int
 	var likes int
 	var lastViewed sql.NullTime
+	var watchSeconds float64
 
 	err := s.db.QueryRow(`
-		SELECT views, likes, last_viewed FROM video_stats WHERE path = ?
-	`, path).Scan(&views, &likes, &lastViewed)
+		SELECT views, likes, last_viewed, watch_seconds FROM video_stats WHERE path = ?
+	`, path).Scan(&views, &likes, &lastViewed, &watchSeconds)
 
 	if err != nil {
 		return
 	}
 
-	daysSinceViewed := 0.0
+	var lastViewedAt time.Time
 	if lastViewed.Valid {
-		daysSinceViewed = time.Since(lastViewed.Time).Hours() / 24
+		lastViewedAt = lastViewed.Time
 	}
+	hotness := ComputeHotness(s.hotness, views, likes, watchSeconds, lastViewedAt, time.Now()).Total
+
+	s.db.Exec(`UPDATE video_stats SET hotness = ? WHERE path = ?`, hotness, path)
+}
 
-	recencyBonus := 0.0
-	if daysSinceViewed < 7 {
-		recencyBonus = (7 - daysSinceViewed) * 10
+// RecomputeHotness re-runs the hotness formula over every row, e.g. after changing the weights
+// Returns the number of rows updated
+func (s *Storage) RecomputeHotness() int {
+	rows, err := s.db.Query(`SELECT path FROM video_stats`)
+	if err != nil {
+		return 0
 	}
 
-	hotness := float64(views)*1.0 + float64(likes)*5.0 + recencyBonus
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	rows.Close()
 
-	s.db.Exec(`UPDATE video_stats SET hotness = ? WHERE path = ?`, hotness, path)
+	for _, path := range paths {
+		s.updateHotness(path)
+	}
+	return len(paths)
 }
 
 // GetThumbnailHash retrieves the thumbnail hash for a video path
@@ -206,6 +311,57 @@ func (s *Storage) GetPreviewHash(path string) string {
 	return hash.String
 }
 
+// GetCustomThumbnail returns the content hash an uploaded poster of a video is
+// stored under, empty when it has none
+func (s *Storage) GetCustomThumbnail(path string) string {
+	var hash sql.NullString
+	err := s.db.QueryRow(`SELECT custom_thumbnail FROM video_stats WHERE path = ?`, path).Scan(&hash)
+	if err != nil {
+		return ""
+	}
+	return hash.String
+}
+
+// SetCustomThumbnail records the content hash of a video's uploaded poster, empty to remove it
+// Thumbnail regeneration only touches thumbnail_hash, so the poster stays in place.
+func (s *Storage) SetCustomThumbnail(path, name, hash string) {
+	s.db.Exec(`
+		INSERT INTO video_stats (path, name, custom_thumbnail, updated_at)
+		VALUES (?, ?, NULLIF(?, ''), CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			custom_thumbnail = NULLIF(?, ''),
+			name = COALESCE(NULLIF(?, ''), name),
+			updated_at = CURRENT_TIMESTAMP
+	`, path, name, hash, hash, name)
+}
+
+// GeneratedHashes are the thumbnail and preview hashes recorded for a video
+type GeneratedHashes struct {
+	Thumbnail string
+	Preview   string
+}
+
+// GetAllGeneratedHashes returns the recorded thumbnail and preview hashes by video path
+func (s *Storage) GetAllGeneratedHashes() map[string]GeneratedHashes {
+	result := make(map[string]GeneratedHashes)
+
+	rows, err := s.db.Query(`SELECT path, COALESCE(thumbnail_hash, ''), COALESCE(preview_hash, '') FROM video_stats`)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var hashes GeneratedHashes
+		if err := rows.Scan(&path, &hashes.Thumbnail, &hashes.Preview); err != nil {
+			continue
+		}
+		result[path] = hashes
+	}
+	return result
+}
+
 // SetThumbnailHash updates the thumbnail hash for a video path
 func (s *Storage) SetThumbnailHash(path, name, hash string) {
 	s.db.Exec(`
@@ -229,3 +385,101 @@ func (s *Storage) SetPreviewHash(path, name, hash string) {
 			updated_at = CURRENT_TIMESTAMP
 	`, path, name, hash, hash, name)
 }
+
+// GetPosition retrieves the user's last playback position (seconds) for a video path
+func (s *Storage) GetPosition(path, username string) float64 {
+	var position float64
+	err := s.db.QueryRow(`SELECT position_sec FROM user_video_stats WHERE username = ? AND path = ?`, username, path).Scan(&position)
+	if err != nil {
+		return 0
+	}
+	return position
+}
+
+// SetPosition updates the user's last playback position (seconds) for a video path
+func (s *Storage) SetPosition(path, name, username string, position float64) {
+	s.ensureStats(path, name)
+	s.db.Exec(`
+		INSERT INTO user_video_stats (username, path, position_sec, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			position_sec = ?,
+			updated_at = CURRENT_TIMESTAMP
+	`, username, path, position, position)
+}
+
+// AddWatchTime adds watched seconds to a video and marks it completed if it was finished
+// Both the user's own and the total watch time are updated. A completed video stays completed
+func (s *Storage) AddWatchTime(path, name, username string, seconds float64, completed bool) {
+	_, err := s.db.Exec(`
+		INSERT INTO video_stats (path, name, watch_seconds, completed, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(path) DO UPDATE SET
+			watch_seconds = watch_seconds + ?,
+			completed = MAX(completed, ?),
+			name = COALESCE(NULLIF(?, ''), name),
+			updated_at = CURRENT_TIMESTAMP
+	`, path, name, seconds, completed, seconds, completed, name)
+
+	if err != nil {
+		return
+	}
+
+	s.db.Exec(`
+		INSERT INTO user_video_stats (username, path, watch_seconds, completed, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(username, path) DO UPDATE SET
+			watch_seconds = watch_seconds + ?,
+			completed = MAX(completed, ?),
+			updated_at = CURRENT_TIMESTAMP
+	`, username, path, seconds, completed, seconds, completed)
+
+	if s.hotness.ByWatchTime {
+		s.updateHotness(path)
+	}
+}
+
+// ClaimLegacyStats assigns the per-video likes, positions and watch time recorded before
+// stats were per-user to the given user. It only runs while no per-user stats exist
+func (s *Storage) ClaimLegacyStats(username string) {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM user_video_stats`).Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	s.db.Exec(`
+		INSERT OR IGNORE INTO user_video_stats (username, path, views, liked, last_viewed, position_sec, watch_seconds, completed)
+		SELECT ?, path, views, liked, last_viewed, position_sec, watch_seconds, completed
+		FROM video_stats
+		WHERE views > 0 OR liked = 1 OR position_sec > 0 OR watch_seconds > 0 OR completed = 1
+	`, username)
+}
+
+// GetReferencedHashes returns every thumbnail, preview, content and custom poster hash stored for any video
+// Storyboards and other per-content caches are named by the content hash
+func (s *Storage) GetReferencedHashes() map[string]bool {
+	result := make(map[string]bool)
+
+	rows, err := s.db.Query(`
+		SELECT thumbnail_hash FROM video_stats WHERE thumbnail_hash IS NOT NULL AND thumbnail_hash != ''
+		UNION
+		SELECT preview_hash FROM video_stats WHERE preview_hash IS NOT NULL AND preview_hash != ''
+		UNION
+		SELECT content_hash FROM video_stats WHERE content_hash IS NOT NULL AND content_hash != ''
+		UNION
+		SELECT custom_thumbnail FROM video_stats WHERE custom_thumbnail IS NOT NULL AND custom_thumbnail != ''
+	`)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			continue
+		}
+		result[hash] = true
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"strings"
+)
+
+// TagCount is a tag and the number of videos carrying it
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag trims and lowercases a tag name so "Music " and "music" are the same tag
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// AddTag attaches a tag to a video, creating the tag if needed
+func (s *Storage) AddTag(path, tag string) bool {
+	tag = NormalizeTag(tag)
+	if tag == "" {
+		return false
+	}
+
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO tags (name) VALUES (?)`, tag)
+	if err != nil {
+		return false
+	}
+
+	_, err = s.db.Exec(`
+		INSERT OR IGNORE INTO video_tags (video_path, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+	`, path, tag)
+	return err == nil
+}
+
+// RemoveTag detaches a tag from a video
+// Tags no longer used by any video are deleted
+func (s *Storage) RemoveTag(path, tag string) bool {
+	tag = NormalizeTag(tag)
+
+	result, err := s.db.Exec(`
+		DELETE FROM video_tags
+		WHERE video_path = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+	`, path, tag)
+	if err != nil {
+		return false
+	}
+
+	s.db.Exec(`DELETE FROM tags WHERE id NOT IN (SELECT DISTINCT tag_id FROM video_tags)`)
+
+	affected, _ := result.RowsAffected()
+	return affected > 0
+}
+
+// GetTags returns the tags of a video, sorted by name
+func (s *Storage) GetTags(path string) []string {
+	tags := []string{}
+
+	rows, err := s.db.Query(`
+		SELECT t.name FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_path = ?
+		ORDER BY t.name
+	`, path)
+	if err != nil {
+		return tags
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			tags = append(tags, name)
+		}
+	}
+	return tags
+}
+
+// GetAllVideoTags returns the tags of every tagged video, keyed by video path
+func (s *Storage) GetAllVideoTags() map[string][]string {
+	result := make(map[string][]string)
+
+	rows, err := s.db.Query(`
+		SELECT vt.video_path, t.name FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		ORDER BY t.name
+	`)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, name string
+		if err := rows.Scan(&path, &name); err == nil {
+			result[path] = append(result[path], name)
+		}
+	}
+	return result
+}
+
+// ListTags returns all tags with their video counts, most used first
+func (s *Storage) ListTags() []TagCount {
+	tags := []TagCount{}
+
+	rows, err := s.db.Query(`
+		SELECT t.name, COUNT(vt.video_path) AS count FROM tags t
+		LEFT JOIN video_tags vt ON vt.tag_id = t.id
+		GROUP BY t.id
+		ORDER BY count DESC, t.name
+	`)
+	if err != nil {
+		return tags
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
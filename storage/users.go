@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserExists is returned when creating a user whose name is taken
+var ErrUserExists = errors.New("user already exists")
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// User is an account stored in the database
+type User struct {
+	Username  string    `json:"username"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateUser adds an account with a bcrypt-hashed password
+func (s *Storage) CreateUser(username, password string, admin bool) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT OR IGNORE INTO users (username, password_hash, admin)
+		VALUES (?, ?, ?)
+	`, username, string(hash), admin)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrUserExists
+	}
+	return nil
+}
+
+// AuthenticateUser checks a username and password, returning the user or nil
+func (s *Storage) AuthenticateUser(username, password string) *User {
+	var user User
+	var hash string
+	var createdAt sql.NullTime
+
+	err := s.db.QueryRow(`
+		SELECT username, password_hash, admin, created_at FROM users WHERE username = ?
+	`, username).Scan(&user.Username, &hash, &user.Admin, &createdAt)
+	if err != nil {
+		// Hash anyway so unknown users take as long as wrong passwords
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("streamlet"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil
+	}
+
+	if createdAt.Valid {
+		user.CreatedAt = createdAt.Time
+	}
+	return &user
+}
+
+// ListUsers returns all stored accounts ordered by name
+func (s *Storage) ListUsers() []User {
+	users := []User{}
+
+	rows, err := s.db.Query(`SELECT username, admin, created_at FROM users ORDER BY username`)
+	if err != nil {
+		return users
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		var createdAt sql.NullTime
+		if err := rows.Scan(&user.Username, &user.Admin, &createdAt); err != nil {
+			continue
+		}
+		if createdAt.Valid {
+			user.CreatedAt = createdAt.Time
+		}
+		users = append(users, user)
+	}
+	return users
+}